@@ -0,0 +1,85 @@
+// Command subtitles-server serves the render and job APIs over HTTP.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/jobs"
+	"github.com/elweday/go-subtitles/pkg/renderer"
+	"github.com/elweday/go-subtitles/pkg/safeurl"
+	"github.com/elweday/go-subtitles/pkg/server"
+	"github.com/elweday/go-subtitles/pkg/utils"
+)
+
+func main() {
+	cfg := server.DefaultConfig
+	if port := os.Getenv("PORT"); port != "" {
+		cfg.Addr = ":" + port
+	}
+	notifier := jobs.DefaultNotifier
+	notifier.Secret = []byte(os.Getenv("SUBTITLES_CALLBACK_SECRET"))
+	cfg.Jobs = jobs.Options{
+		Workers:     max(runtime.NumCPU()/2, 1),
+		QueueSize:   32,
+		MaxAttempts: jobs.DefaultMaxAttempts,
+		Notifier:    &notifier,
+	}
+
+	flag.StringVar(&cfg.Addr, "addr", cfg.Addr, "listen address")
+	flag.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "time to read a whole request, uploads included")
+	flag.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "time to write a whole response, streamed videos included")
+	flag.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "time to keep idle connections open")
+	flag.DurationVar(&cfg.RenderTimeout, "render-timeout", cfg.RenderTimeout, "longest a synchronous render may take")
+	flag.DurationVar(&cfg.DrainDelay, "drain-delay", cfg.DrainDelay, "time to keep serving after /readyz fails on shutdown")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time given to running renders to finish on shutdown")
+	flag.Int64Var(&cfg.Limits.Video, "max-video-bytes", cfg.Limits.Video, "largest uploaded or downloaded video")
+	flag.Int64Var(&cfg.Limits.Transcript, "max-transcript-bytes", cfg.Limits.Transcript, "largest transcript")
+	flag.Int64Var(&cfg.Limits.Config, "max-config-bytes", cfg.Limits.Config, "largest config")
+	flag.StringVar(&cfg.Jobs.Dir, "jobs-dir", os.Getenv("SUBTITLES_JOBS_DIR"), "where jobs are stored, the job API is disabled when empty")
+	flag.IntVar(&cfg.Jobs.Workers, "workers", cfg.Jobs.Workers, "jobs rendered at once")
+	flag.IntVar(&cfg.Jobs.QueueSize, "queue", cfg.Jobs.QueueSize, "jobs waiting for a worker before new ones are refused")
+	flag.IntVar(&cfg.Jobs.MaxAttempts, "attempts", cfg.Jobs.MaxAttempts, "starts of a job interrupted by restarts before it fails")
+	flag.DurationVar(&cfg.Jobs.Retention, "job-retention", jobs.DefaultRetention, "time finished jobs and their videos are kept, forever when negative")
+	flag.BoolVar(&cfg.Firestore, "firestore", false, "serve /render/firestore, which renders requests stored in Firestore with the credentials sent along")
+	flag.StringVar(&utils.TempDir, "temp-dir", os.Getenv("SUBTITLES_TEMP_DIR"), "`directory` uploads are spooled to, the system temporary directory when empty")
+	allowedHosts := flag.String("allowed-hosts", os.Getenv("SUBTITLES_ALLOWED_HOSTS"), "comma separated `hosts` that video and callback URLs may point to, any public host when empty")
+	flag.Parse()
+	safeurl.AllowedHosts = safeurl.ParseHosts(*allowedHosts)
+	handlers.DownloadLimit = cfg.Limits.Video
+
+	if err := renderer.CheckTools(); err != nil {
+		log.Printf("warning: %v, renders will fail\n", err)
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("cannot start server: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-errs:
+		log.Fatalf("server failed: %v\n", err)
+	case <-ctx.Done():
+	}
+	// a second signal kills the process without waiting
+	stop()
+	log.Printf("shutting down, waiting up to %v for running renders\n", cfg.ShutdownTimeout)
+	if err := srv.Shutdown(context.Background()); err != nil {
+		log.Printf("shutdown: %v\n", err)
+	}
+	if err := <-errs; err != nil {
+		log.Printf("server: %v\n", err)
+	}
+}
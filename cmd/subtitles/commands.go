@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/renderer"
+	"github.com/elweday/go-subtitles/pkg/styles"
+	"github.com/elweday/go-subtitles/pkg/transcript"
+)
+
+func runProbe(ctx context.Context, args []string) error {
+	fs := newFlags("probe", "[-json] video")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("probe needs one video")
+	}
+
+	meta, err := renderer.FFprobeMetadata(ctx, fs.Arg(0))
+	if err != nil {
+		if renderer.CheckTools() == nil {
+			return invalid(err)
+		}
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(meta)
+	}
+	fmt.Printf("size:       %dx%d\n", meta.Width, meta.Height)
+	if meta.DisplayAspect != "" {
+		fmt.Printf("aspect:     %s\n", meta.DisplayAspect)
+	}
+	fmt.Printf("rotation:   %d\n", meta.Rotation)
+	fmt.Printf("frame rate: %.3f avg, %.3f real, %.3f used\n", meta.AvgFrameRate, meta.RealFrameRate, meta.FrameRate())
+	fmt.Printf("duration:   %.3fs\n", meta.Duration)
+	fmt.Printf("audio:      %t\n", meta.HasAudio)
+	return nil
+}
+
+func runConvert(ctx context.Context, args []string) error {
+	fs := newFlags("convert", "[-from format] [-to format] [-config file] input [output]")
+	from := fs.String("from", "", "input format, json, srt or vtt; from the extension by default")
+	to := fs.String("to", "", "output format; from the extension by default")
+	config := fs.String("config", "", "config `file` whose cue options group words into SRT and WebVTT cues")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return usageError("convert needs an input and an optional output")
+	}
+	input, output := fs.Arg(0), fs.Arg(1)
+
+	inFormat, err := pickFormat(*from, input)
+	if err != nil {
+		return err
+	}
+	if output == "-" {
+		output = ""
+	}
+	if *to == "" && output == "" {
+		return usageError("convert needs -to when writing to stdout")
+	}
+	outFormat, err := pickFormat(*to, output)
+	if err != nil {
+		return err
+	}
+
+	opts, err := (&handlers.LocalIOHandler{ConfigPath: *config}).Options()
+	if err != nil {
+		return invalid(err)
+	}
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	words, err := transcript.Read(data, inFormat)
+	if err != nil {
+		return invalid(fmt.Errorf("file %s: %v", input, err))
+	}
+	sort.SliceStable(words, func(i, j int) bool { return words[i].Time < words[j].Time })
+
+	if output == "" {
+		return transcript.Write(os.Stdout, words, outFormat, opts)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := transcript.Write(f, words, outFormat, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pickFormat returns the format named by flag, or else the format of path.
+func pickFormat(flag, path string) (transcript.Format, error) {
+	var f transcript.Format
+	var err error
+	if flag != "" {
+		f, err = transcript.ParseFormat(flag)
+	} else {
+		f, err = transcript.FormatOf(path)
+	}
+	if err != nil {
+		return "", usageError("%v", err)
+	}
+	return f, nil
+}
+
+func runStyles(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] != "list" {
+		return usageError("usage: subtitles styles list")
+	}
+	names := make([]string, 0, len(styles.Registry))
+	for name := range styles.Registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == handlers.DefaultOptions.Style || (handlers.DefaultOptions.Style == "" && name == "scrollingBox") {
+			fmt.Println(name, "(default)")
+			continue
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func runValidate(ctx context.Context, args []string) error {
+	fs := newFlags("validate", "[-config file] [-transcript file] [-video file]")
+	var cfg configFlags
+	cfg.register(fs)
+	words := fs.String("transcript", "", "transcript `file` to check")
+	video := fs.String("video", "", "video `file` to probe")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	handler, err := cfg.handler()
+	if err != nil {
+		return err
+	}
+	opts, err := handler.Options()
+	if err != nil {
+		return invalid(err)
+	}
+	fmt.Println("config: ok")
+
+	if *words != "" {
+		handler.TranscriptPath = *words
+		parsed, err := handler.Words(opts)
+		if err != nil {
+			return invalid(err)
+		}
+		fmt.Printf("transcript: ok, %d words\n", len(parsed))
+	}
+	if *video != "" {
+		if err := renderer.CheckTools(); err != nil {
+			return &exitError{exitMissingTool, err}
+		}
+		meta, err := renderer.FFprobeMetadata(ctx, *video)
+		if err != nil {
+			return invalid(err)
+		}
+		fmt.Printf("video: ok, %dx%d, %.3fs\n", meta.Width, meta.Height, meta.Duration)
+
+		// lines are only known once the frame size is
+		if *words != "" {
+			meta.Apply(&opts)
+			parsed, err := handler.Words(opts)
+			if err != nil {
+				return invalid(err)
+			}
+			warnings, err := renderer.LineBreakWarnings(parsed, opts)
+			if err != nil {
+				return err
+			}
+			for _, w := range warnings {
+				fmt.Printf("line breaking: %s\n", w)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Command subtitles renders captions onto videos and works with transcripts
+// and configs locally.
+//
+// Usage:
+//
+//	subtitles render   -video in.mp4 -transcript words.json [-config c.yaml] [-o out.mp4]
+//	subtitles preview  -video in.mp4 -transcript words.json (-at 3.5 | -count 9) [-o out.png]
+//	subtitles probe    video
+//	subtitles convert  [-from json|srt|vtt] [-to json|srt|vtt] input [output]
+//	subtitles styles   list
+//	subtitles validate [-config c.yaml] [-transcript words.json]
+//
+// Exit codes: 0 success, 1 render failure, 2 bad usage, 3 invalid config,
+// transcript or video, 4 ffmpeg or ffprobe missing, 130 interrupted.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/renderer"
+)
+
+const (
+	exitFailure     = 1
+	exitUsage       = 2
+	exitInvalid     = 3
+	exitMissingTool = 4
+	exitInterrupted = 130
+)
+
+// exitError is an error with the exit code it should end the process with.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+func usageError(format string, a ...any) error {
+	return &exitError{exitUsage, fmt.Errorf(format, a...)}
+}
+
+func invalid(err error) error {
+	return &exitError{exitInvalid, err}
+}
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"render", "render captions onto a video", runRender},
+	{"preview", "draw the captions over video frames as a PNG", runPreview},
+	{"probe", "print what the renderer knows about a video", runProbe},
+	{"convert", "convert a transcript between json, srt and vtt", runConvert},
+	{"styles", "list the caption styles", runStyles},
+	{"validate", "check a config and a transcript", runValidate},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: subtitles <command> [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun subtitles <command> -h for the flags of a command\n")
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		usage()
+		if len(os.Args) < 2 {
+			os.Exit(exitUsage)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			code := exitCode(ctx, c.run(ctx, os.Args[2:]))
+			stop()
+			os.Exit(code)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "subtitles: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(exitUsage)
+}
+
+// exitCode prints err and returns the exit code for it.
+func exitCode(ctx context.Context, err error) int {
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "subtitles: %v\n", err)
+
+	var exitErr *exitError
+	var configErr *handlers.ConfigError
+	var reqErr *handlers.RequestError
+	switch {
+	case ctx.Err() != nil:
+		return exitInterrupted
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.As(err, &configErr), errors.As(err, &reqErr):
+		return exitInvalid
+	case renderer.CheckTools() != nil:
+		return exitMissingTool
+	}
+	return exitFailure
+}
+
+// newFlags returns a flag set for a command that reports errors instead of
+// exiting.
+func newFlags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: subtitles %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags of a command, which may come after its arguments.
+// It keeps the -h error so it exits with 0, and marks other errors as usage
+// errors.
+func parse(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return err
+			}
+			return &exitError{exitUsage, err}
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		// everything after a "--" is an argument
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	// leave the arguments in fs.Args
+	return fs.Parse(append([]string{"--"}, positional...))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+)
+
+func runPreview(ctx context.Context, args []string) error {
+	fs := newFlags("preview", "-video file -transcript file (-at time[,time...] | -count n) [flags]")
+	var cfg configFlags
+	cfg.register(fs)
+	video := fs.String("video", "", "input video `file`")
+	words := fs.String("transcript", "", "transcript `file`, .json, .srt or .vtt")
+	at := fs.String("at", "", "`times` to preview, in seconds or m:s, comma separated; more than one makes a contact sheet")
+	count := fs.Int("count", 0, "make a contact sheet of `n` frames spread over the captions")
+	columns := fs.Int("columns", 0, "tiles per row of a contact sheet, 0 for a square grid")
+	width := fs.Int("width", 0, "width of a contact sheet tile in pixels, 0 for the default")
+	output := fs.String("o", "", "output PNG `file`, next to the video by default, - for stdout")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *video == "" || *words == "" {
+		fs.Usage()
+		return usageError("preview needs -video and -transcript")
+	}
+
+	times, err := handlers.ParseTimes(*at)
+	if err != nil {
+		return usageError("-at: %v", err)
+	}
+	req := handlers.PreviewRequest{Times: times, Count: *count, Columns: *columns, TileWidth: *width}
+	if err := req.Validate(); err != nil {
+		return usageError("%v", err)
+	}
+
+	for _, path := range []string{*video, *words} {
+		if _, err := os.Stat(path); err != nil {
+			return invalid(fmt.Errorf("cannot read file %s", path))
+		}
+	}
+	handler, err := cfg.handler()
+	if err != nil {
+		return err
+	}
+	handler.InputVideoPath = *video
+	handler.TranscriptPath = *words
+
+	opts, err := handler.Options()
+	if err != nil {
+		return invalid(err)
+	}
+	if _, err := handler.Words(opts); err != nil {
+		return invalid(err)
+	}
+
+	img, err := handlers.Preview(ctx, handler, req)
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err := os.Stdout.Write(img)
+		return err
+	}
+	path := *output
+	if path == "" {
+		path = filepath.Join(filepath.Dir(*video), handlers.PreviewFilename(filepath.Base(*video)))
+	}
+	if err := os.WriteFile(path, img, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+	return nil
+}
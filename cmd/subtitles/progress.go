@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elweday/go-subtitles/pkg/renderer"
+)
+
+const barWidth = 30
+
+// progressBar draws render progress on one terminal line. When the output
+// is not a terminal it prints a line per stage instead.
+type progressBar struct {
+	w        io.Writer
+	terminal bool
+	stage    string
+	total    int
+	drawn    bool
+}
+
+func newProgressBar(f *os.File) *progressBar {
+	info, err := f.Stat()
+	return &progressBar{w: f, terminal: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+func (b *progressBar) update(p renderer.Progress) {
+	if !b.terminal {
+		if p.Stage != b.stage {
+			fmt.Fprintf(b.w, "%s %d frames\n", p.Stage, p.TotalFrames)
+		}
+		b.stage = p.Stage
+		return
+	}
+
+	// drawing and encoding each count for half of the bar
+	done := 0.0
+	if p.TotalFrames > 0 {
+		done = float64(p.FramesDrawn+p.FramesEncoded) / float64(2*p.TotalFrames)
+	}
+	done = min(max(done, 0), 1)
+	filled := int(done * barWidth)
+	line := fmt.Sprintf("\r[%s%s] %3.0f%% %-8s %d/%d frames",
+		strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled),
+		done*100, p.Stage, max(p.FramesDrawn, p.FramesEncoded), p.TotalFrames)
+	if p.ETA > 0 {
+		line += " ETA " + time.Duration(p.ETA*float64(time.Second)).Round(time.Second).String()
+	}
+	// clear what is left of a longer previous line
+	fmt.Fprint(b.w, line+"\033[K")
+	b.drawn = true
+	b.stage = p.Stage
+	b.total = p.TotalFrames
+}
+
+// finish ends the progress line. It is a no-op on a nil bar.
+func (b *progressBar) finish(ok bool) {
+	if b == nil || !b.drawn {
+		return
+	}
+	if ok {
+		b.update(renderer.Progress{Stage: renderer.StageDone, TotalFrames: b.total, FramesDrawn: b.total, FramesEncoded: b.total})
+	}
+	fmt.Fprintln(b.w)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/renderer"
+	"gopkg.in/yaml.v3"
+)
+
+// setFlags collects repeated -set key=value flags.
+type setFlags []string
+
+func (s *setFlags) String() string { return strings.Join(*s, ",") }
+
+func (s *setFlags) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("expected key=value, got %q", v)
+	}
+	*s = append(*s, v)
+	return nil
+}
+
+// configFlags are the flags that pick the options of a render.
+type configFlags struct {
+	config  string
+	style   string
+	set     setFlags
+	profile string
+	codec   string
+	crf     int
+}
+
+func (c *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.config, "config", "", "JSON or YAML config `file`")
+	fs.StringVar(&c.style, "style", "", "caption style, see subtitles styles list")
+	fs.Var(&c.set, "set", "override a config field, as `key=value` with a YAML value; repeatable")
+	fs.StringVar(&c.profile, "profile", "", "encoding profile, such as tiktok-1080x1920 or web-vp9")
+	fs.StringVar(&c.codec, "codec", "", "video codec: x264, x265, vp9 or av1")
+	fs.IntVar(&c.crf, "crf", 0, "constant rate factor, 0 for the codec default")
+}
+
+// handler returns a LocalIOHandler with the config file and the overrides
+// of the flags.
+func (c *configFlags) handler() (*handlers.LocalIOHandler, error) {
+	handler := &handlers.LocalIOHandler{ConfigPath: c.config, Style: c.style}
+	if len(c.set) == 0 && c.profile == "" && c.codec == "" && c.crf == 0 {
+		return handler, nil
+	}
+
+	config := map[string]any{}
+	if c.config != "" {
+		data, err := os.ReadFile(c.config)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read file %s", c.config)
+		}
+		// JSON is YAML, so both kinds of config are read the same way
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, invalid(fmt.Errorf("file %s: %v", c.config, err))
+		}
+	}
+	for _, kv := range c.set {
+		key, value, _ := strings.Cut(kv, "=")
+		var v any
+		if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+			return nil, usageError("-set %s: %v", kv, err)
+		}
+		config[key] = v
+	}
+
+	enc, _ := config["encoding"].(map[string]any)
+	if enc == nil {
+		enc = map[string]any{}
+	}
+	if c.profile != "" {
+		enc["profile"] = c.profile
+	}
+	if c.codec != "" {
+		enc["codec"] = c.codec
+	}
+	if c.crf != 0 {
+		enc["crf"] = c.crf
+	}
+	if len(enc) > 0 {
+		config["encoding"] = enc
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	handler.Config = data
+	return handler, nil
+}
+
+func runRender(ctx context.Context, args []string) error {
+	fs := newFlags("render", "-video file -transcript file [flags]")
+	var cfg configFlags
+	cfg.register(fs)
+	video := fs.String("video", "", "input video `file`")
+	words := fs.String("transcript", "", "transcript `file`, .json, .srt or .vtt")
+	output := fs.String("o", "", "output `file`, next to the video by default")
+	audio := fs.String("audio", "", "audio `file` replacing the audio of the video")
+	music := fs.String("music", "", "background music `file` mixed under the audio")
+	quiet := fs.Bool("quiet", false, "don't show progress")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *video == "" || *words == "" {
+		fs.Usage()
+		return usageError("render needs -video and -transcript")
+	}
+
+	for _, path := range []string{*video, *words, *audio, *music} {
+		if _, err := os.Stat(path); path != "" && err != nil {
+			return invalid(fmt.Errorf("cannot read file %s", path))
+		}
+	}
+
+	handler, err := cfg.handler()
+	if err != nil {
+		return err
+	}
+	handler.InputVideoPath = *video
+	handler.TranscriptPath = *words
+	handler.AudioPath = *audio
+	handler.MusicPath = *music
+
+	opts, err := handler.Options()
+	if err != nil {
+		return invalid(err)
+	}
+	handler.OutputPath = *output
+	if handler.OutputPath == "" {
+		enc, _ := renderer.ResolveEncoding(opts.Encoding)
+		name := handlers.OutputFilename(filepath.Base(*video), renderer.FileExtension(enc.Container))
+		handler.OutputPath = filepath.Join(filepath.Dir(*video), name)
+	}
+	if _, err := handler.Words(opts); err != nil {
+		return invalid(err)
+	}
+
+	var bar *progressBar
+	if !*quiet {
+		bar = newProgressBar(os.Stderr)
+		handler.Progress = bar.update
+	}
+	start := time.Now()
+	err = handlers.Render(ctx, handler)
+	bar.finish(err == nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s in %s\n", handler.OutputPath, time.Since(start).Round(100*time.Millisecond))
+	return nil
+}
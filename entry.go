@@ -2,72 +2,148 @@ package renderSubtitles
 
 import (
 	"fmt"
-	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/jobs"
+	"github.com/elweday/go-subtitles/pkg/safeurl"
+	"github.com/elweday/go-subtitles/pkg/server"
+	"github.com/elweday/go-subtitles/pkg/utils"
 
-	"encoding/json"
 	"net/http"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
-func renderSubtitles(handler handlers.IOHandler) error {
-	vid, err := handler.Read()
-	if err != nil {
-		return err
+// renderTimeout is a little under the timeout of the function.
+const renderTimeout = 9 * time.Minute
+
+func init() {
+	// video and callback URLs may only point to these hosts when set
+	safeurl.AllowedHosts = safeurl.ParseHosts(os.Getenv("SUBTITLES_ALLOWED_HOSTS"))
+	// the temporary directory of a function is in memory, a mounted disk
+	// takes larger uploads
+	utils.TempDir = os.Getenv("SUBTITLES_TEMP_DIR")
+	handlers.DownloadLimit = functionLimits.Video
+
+	functions.HTTP("RenderSubtitles", RenderSubtitles)
+	functions.HTTP("RenderSubtitlesEvents", RenderSubtitlesEvents)
+	functions.HTTP("RenderSubtitlesUpload", RenderSubtitlesUpload)
+	functions.HTTP("RenderSubtitlesFirestore", RenderSubtitlesFirestore)
+	functions.HTTP("RenderSubtitlesPreview", RenderSubtitlesPreview)
+	functions.HTTP("RenderSubtitlesPreviewUpload", RenderSubtitlesPreviewUpload)
+	functions.HTTP("Jobs", Jobs)
+}
+
+var (
+	jobsOnce    sync.Once
+	jobsManager *jobs.Manager
+	jobsErr     error
+)
+
+// Jobs serves the asynchronous job API, see jobs.Manager.ServeHTTP. Jobs run
+// in the background, so it needs an instance that keeps running, such as the
+// server in func/ started with FUNCTION_TARGET=Jobs. SUBTITLES_JOBS_DIR,
+// SUBTITLES_JOB_WORKERS, SUBTITLES_JOB_QUEUE, SUBTITLES_JOB_ATTEMPTS and
+// SUBTITLES_JOB_RETENTION configure the job manager, and
+// SUBTITLES_CALLBACK_SECRET signs the callbacks of finished jobs.
+func Jobs(w http.ResponseWriter, r *http.Request) {
+	jobsOnce.Do(func() {
+		dir := os.Getenv("SUBTITLES_JOBS_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "subtitles-jobs")
+		}
+		notifier := jobs.DefaultNotifier
+		notifier.Secret = []byte(os.Getenv("SUBTITLES_CALLBACK_SECRET"))
+		jobsManager, jobsErr = jobs.NewManager(jobs.Options{
+			Dir:         dir,
+			Workers:     envInt("SUBTITLES_JOB_WORKERS", runtime.NumCPU()/2),
+			QueueSize:   envInt("SUBTITLES_JOB_QUEUE", 32),
+			MaxAttempts: envInt("SUBTITLES_JOB_ATTEMPTS", jobs.DefaultMaxAttempts),
+			Retention:   envDuration("SUBTITLES_JOB_RETENTION", jobs.DefaultRetention),
+			Limits:      functionLimits,
+			Notifier:    &notifier,
+		})
+	})
+	if jobsErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "job manager unavailable: "+jobsErr.Error())
+		return
 	}
-	err = vid.RenderWithSubtitles()
+	jobsManager.ServeHTTP(w, r)
+}
+
+// envInt reads a number from the environment, returning def when it is unset
+// or invalid.
+func envInt(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
 	if err != nil {
-		return err
+		return def
 	}
-	err = handler.SaveVideo(vid.OutputVideo)
+	return n
+}
+
+// envDuration reads a duration such as "12h" from the environment, returning
+// def when it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
 	if err != nil {
-		return err
+		return def
 	}
-
-	return nil
-
+	return d
 }
 
-func init() {
-	functions.HTTP("RenderSubtitles", RenderSubtitles)
+// api is the render API with a timeout that stops a render, and kills
+// ffmpeg, shortly before the function itself would be stopped.
+var api = &server.RenderAPI{Timeout: renderTimeout, Limits: functionLimits}
+
+// functionLimits caps uploads to the function. Uploaded videos are spooled
+// to the temporary directory, which counts against the memory of the
+// function, so the video limit is lower than the server's.
+// SUBTITLES_MAX_VIDEO_BYTES raises it, along with SUBTITLES_TEMP_DIR.
+var functionLimits = handlers.UploadLimits{
+	Video:      int64(envInt("SUBTITLES_MAX_VIDEO_BYTES", 512<<20)),
+	Transcript: handlers.DefaultUploadLimits.Transcript,
+	Config:     handlers.DefaultUploadLimits.Config,
 }
 
-// HelloHTTP is an HTTP Cloud Function with a request parameter.
+// RenderSubtitles takes a JSON render request and streams back the video.
 func RenderSubtitles(w http.ResponseWriter, r *http.Request) {
-	handler := &handlers.EndPointHandler{}
+	api.Render(w, r)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(handler); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		body, _ := io.ReadAll(r.Body)
-		fmt.Fprint(w, "error parsing request body: "+err.Error(), "\n", string(body))
-		return
-	}
+// RenderSubtitlesUpload takes a multipart/form-data body, see
+// server.RenderAPI.Upload.
+func RenderSubtitlesUpload(w http.ResponseWriter, r *http.Request) {
+	api.Upload(w, r)
+}
 
-	if handler.InputVideo == nil || handler.Transcript == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "invalid request body, missing inputVideo or transcript")
-		return
-	}
+// RenderSubtitlesEvents streams server-sent events, see
+// server.RenderAPI.Events.
+func RenderSubtitlesEvents(w http.ResponseWriter, r *http.Request) {
+	api.Events(w, r)
+}
 
-	// render subtitle start
-	vid, err := handler.Read()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "couldn't read body: "+err.Error())
-		return
+// RenderSubtitlesFirestore renders a request stored in a Firestore document
+// and reports progress in its status field, see server.RenderAPI.Firestore.
+// Requests must carry their own credentials.
+func RenderSubtitlesFirestore(w http.ResponseWriter, r *http.Request) {
+	api.Firestore(w, r)
+}
 
-	}
-	err = vid.RenderWithSubtitles()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "couldn't render subtitles for video: "+err.Error())
-		return
-	}
+// RenderSubtitlesPreview answers with a PNG of the captions over one or more
+// video frames, see server.RenderAPI.Preview.
+func RenderSubtitlesPreview(w http.ResponseWriter, r *http.Request) {
+	api.Preview(w, r)
+}
 
-	w.Header().Add("Content-Type", "video/mp4")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, string(vid.OutputVideo))
-	// fmt.Fprint(w, handler.Out)
+// RenderSubtitlesPreviewUpload is RenderSubtitlesPreview for a
+// multipart/form-data body, see server.RenderAPI.PreviewUpload.
+func RenderSubtitlesPreviewUpload(w http.ResponseWriter, r *http.Request) {
+	api.PreviewUpload(w, r)
 }
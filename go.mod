@@ -11,9 +11,12 @@ require (
 	github.com/fogleman/gg v1.3.0
 	github.com/goki/freetype v1.0.5
 	github.com/google/uuid v1.6.0
+	github.com/rivo/uniseg v0.4.7
+	go.etcd.io/bbolt v1.3.10
 	golang.org/x/exp v0.0.0-20240416160154-fe59bbe5cc7f
 	golang.org/x/image v0.15.0
 	google.golang.org/api v0.177.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/elweday/go-subtitles/pkg/renderer"
+	"github.com/elweday/go-subtitles/pkg/styles"
+	"github.com/elweday/go-subtitles/pkg/types"
+	"github.com/elweday/go-subtitles/pkg/utils"
+	"github.com/elweday/go-subtitles/pkg/utils/interpolation"
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is a problem with one field of a config.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ConfigError lists every invalid field of a config.
+type ConfigError struct {
+	Fields []FieldError
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		lines[i] = f.Field + ": " + f.Message
+	}
+	return "invalid config:\n" + strings.Join(lines, "\n")
+}
+
+func (e *ConfigError) add(field, format string, a ...any) {
+	e.Fields = append(e.Fields, FieldError{field, fmt.Sprintf(format, a...)})
+}
+
+func (e *ConfigError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// configFields maps the accepted config keys, the firestore names and the
+// lowercased Go names, to the fields of SubtitlesOptions. Fields without a
+// firestore tag are set while rendering and can't be configured.
+var configFields = func() map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	t := reflect.TypeOf(types.SubtitlesOptions{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("firestore")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[strings.ToLower(name)] = f
+		fields[strings.ToLower(f.Name)] = f
+	}
+	return fields
+}()
+
+// DecodeConfig reads a JSON or YAML config and merges it over
+// DefaultOptions. Unknown keys and values of the wrong type are reported
+// together in a ConfigError, as are values that fail ValidateOptions.
+func DecodeConfig(data []byte) (types.SubtitlesOptions, error) {
+	opts := DefaultOptions
+
+	raw := map[string]any{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return opts, fmt.Errorf("cannot parse config as JSON: %v", err)
+		}
+	} else if err := yaml.Unmarshal(data, &raw); err != nil {
+		return opts, fmt.Errorf("cannot parse config as YAML: %v", err)
+	}
+
+	errs := &ConfigError{}
+	v := reflect.ValueOf(&opts).Elem()
+	for _, key := range sortedKeys(raw) {
+		f, ok := configFields[strings.ToLower(key)]
+		if !ok {
+			errs.add(key, "unknown field")
+			continue
+		}
+		// YAML values are re-encoded so both formats go through the JSON
+		// decoder and its case-insensitive matching of nested fields
+		value, err := json.Marshal(raw[key])
+		if err != nil {
+			errs.add(key, "unsupported value: %v", err)
+			continue
+		}
+		if err := decodeField(v.FieldByIndex(f.Index), value); err != nil {
+			errs.add(key, "%v", err)
+		}
+	}
+	// fields that failed to decode keep valid defaults, so validating
+	// the rest reports every problem at once
+	if err, ok := ValidateOptions(opts).(*ConfigError); ok {
+		errs.Fields = append(errs.Fields, err.Fields...)
+	}
+	return opts, errs.orNil()
+}
+
+// decodeField decodes value into field, keeping the defaults of nested
+// fields that value leaves out. Slices and maps are replaced as a whole.
+func decodeField(field reflect.Value, value []byte) error {
+	target := reflect.New(field.Type())
+	if k := field.Kind(); k != reflect.Slice && k != reflect.Map {
+		target.Elem().Set(field)
+	}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(target.Interface()); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return fmt.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value)
+		}
+		return err
+	}
+	field.Set(target.Elem())
+	return nil
+}
+
+var (
+	alignments   = []string{"top", "center", "bottom"}
+	anchors      = []string{"left", "center", "right"}
+	layouts      = []string{"", "lines", renderer.LayoutPop}
+	scopes       = []string{"", "word", "line"}
+	phraseBreaks = []string{"", "page", "line"}
+	colorFields  = []string{"fontColor", "fontSelectedColor", "strokeColor", "highlightColor"}
+)
+
+// ValidateOptions checks the configurable fields of opts and returns a
+// ConfigError listing every invalid one.
+func ValidateOptions(opts types.SubtitlesOptions) error {
+	errs := &ConfigError{}
+
+	colors := []string{opts.FontColor, opts.FontSelectedColor, opts.StrokeColor, opts.HighlightColor}
+	for i, c := range colors {
+		checkColor(errs, colorFields[i], c, false)
+	}
+	checkColor(errs, "emphasisStyle.color", opts.EmphasisStyle.Color, true)
+	checkColor(errs, "emphasisStyle.highlightColor", opts.EmphasisStyle.HighlightColor, true)
+
+	positive := map[string]float64{
+		"fontSize":    opts.FontSize,
+		"lineSpacing": opts.LineSpacing,
+		"popFontSize": opts.PopFontSize,
+	}
+	nonNegative := map[string]float64{
+		"strokeWidth":           opts.StrokeWidth,
+		"highlightBorderRadius": float64(opts.HighlightBorderRadius),
+		"highlightPadding":      opts.HighlightPadding,
+		"padding":               float64(opts.Padding),
+		"wordSpacing":           float64(opts.WordSpacing),
+		"cursorBlinkRate":       opts.CursorBlinkRate,
+		"gapThreshold":          opts.GapThreshold,
+		"gapFade":               opts.GapFade,
+		"maxCharsPerLine":       float64(opts.MaxCharsPerLine),
+		"maxCPS":                opts.MaxCPS,
+		"minCueDuration":        opts.MinCueDuration,
+		"maxCueDuration":        opts.MaxCueDuration,
+		"popSpring.stiffness":   opts.PopSpring.Stiffness,
+		"popSpring.damping":     opts.PopSpring.Damping,
+		"popSpring.mass":        opts.PopSpring.Mass,
+		"popEntryDuration":      opts.PopEntryDuration,
+		"popExitDuration":       opts.PopExitDuration,
+		"emphasisStyle.scale":   opts.EmphasisStyle.Scale,
+	}
+	for _, name := range sortedKeys(positive) {
+		if positive[name] <= 0 {
+			errs.add(name, "must be positive, got %v", positive[name])
+		}
+	}
+	for _, name := range sortedKeys(nonNegative) {
+		if nonNegative[name] < 0 {
+			errs.add(name, "must not be negative, got %v", nonNegative[name])
+		}
+	}
+
+	if opts.MaxLines < 1 {
+		errs.add("maxLines", "must be at least 1, got %d", opts.MaxLines)
+	}
+	if opts.GhostOpacity < 0 || opts.GhostOpacity > 1 {
+		errs.add("ghostOpacity", "must be between 0 and 1, got %v", opts.GhostOpacity)
+	}
+	if opts.PopMaxWidth <= 0 || opts.PopMaxWidth > 1 {
+		errs.add("popMaxWidth", "must be above 0 and at most 1, got %v", opts.PopMaxWidth)
+	}
+	if opts.MaxCueDuration > 0 && opts.MinCueDuration > opts.MaxCueDuration {
+		errs.add("minCueDuration", "must not exceed maxCueDuration")
+	}
+
+	checkOneOf(errs, "alignment", opts.Alignment, alignments)
+	checkOneOf(errs, "layout", opts.Layout, layouts)
+	checkOneOf(errs, "typewriterScope", opts.TypewriterScope, scopes)
+	checkOneOf(errs, "phraseBreak", opts.PhraseBreak, phraseBreaks)
+	if _, ok := styles.Registry[opts.Style]; opts.Style != "" && !ok {
+		errs.add("style", "unknown style %q, expected one of %s", opts.Style, strings.Join(sortedKeys(styles.Registry), ", "))
+	}
+	if opts.HighlightEasing != "" {
+		if _, err := interpolation.LookupEasing(opts.HighlightEasing); err != nil {
+			errs.add("highlightEasing", "%v", err)
+		}
+	}
+
+	for _, speaker := range sortedKeys(opts.SpeakerStyles) {
+		s := opts.SpeakerStyles[speaker]
+		prefix := "speakerStyles." + speaker + "."
+		checkColor(errs, prefix+"fontColor", s.FontColor, true)
+		checkColor(errs, prefix+"fontSelectedColor", s.FontSelectedColor, true)
+		checkColor(errs, prefix+"highlightColor", s.HighlightColor, true)
+		if s.Anchor != "" {
+			checkOneOf(errs, prefix+"anchor", s.Anchor, anchors)
+		}
+		if s.Alignment != "" {
+			checkOneOf(errs, prefix+"alignment", s.Alignment, alignments)
+		}
+	}
+
+	if err := utils.ValidateTiming(opts.Timing); err != nil {
+		errs.add("timing", "%v", err)
+	}
+	if _, err := renderer.ResolveEncoding(opts.Encoding); err != nil {
+		errs.add("encoding", "%v", err)
+	}
+	return errs.orNil()
+}
+
+func checkColor(errs *ConfigError, field, value string, optional bool) {
+	if optional && value == "" {
+		return
+	}
+	if _, err := utils.ParseHexColor(value); err != nil {
+		errs.add(field, "invalid hex colour %q", value)
+	}
+}
+
+func checkOneOf(errs *ConfigError, field, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	names := []string{}
+	for _, a := range allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	errs.add(field, "unknown value %q, expected one of %s", value, strings.Join(names, ", "))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
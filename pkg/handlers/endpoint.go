@@ -1,44 +1,217 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"time"
 
 	"github.com/elweday/go-subtitles/pkg/renderer"
+	"github.com/elweday/go-subtitles/pkg/safeurl"
+	"github.com/elweday/go-subtitles/pkg/types"
 	"github.com/elweday/go-subtitles/pkg/utils"
 )
 
+// EndPointHandler reads a render request from a JSON body. The video is
+// either inline in InputVideo or fetched by ffmpeg from InputVideoURL.
 type EndPointHandler struct {
-	InputVideo []byte `json:"inputVideo"`
-	Transcript []byte `json:"transcript"`
-	Config     []byte `json:"config"`
-	Out        []byte
+	InputVideo    []byte          `json:"inputVideo"`
+	InputVideoURL string          `json:"inputVideoUrl"`
+	Transcript    []byte          `json:"transcript"`
+	Config        json.RawMessage `json:"config"` // object, or JSON or YAML text
+	Timing        *types.Timing   `json:"timing"`
+	Audio         []byte          `json:"audio"`
+	Music         []byte          `json:"music"`
+	MusicGain     *float64        `json:"musicGain"`
+	Encoding      *types.Encoding `json:"encoding"`
+	CallbackURL   string          `json:"callbackUrl,omitempty"` // notified when an async job ends
+	Out           io.Writer       `json:"-"`
+	VideoName     string          `json:"-"` // file name of an uploaded video
+	VideoPath     string          `json:"-"` // local video file, set by the server only
+
+	temps tempFiles
+}
+
+// DownloadLimit caps the size of a video fetched from inputVideoUrl.
+var DownloadLimit = DefaultUploadLimits.Video
+
+// downloadClient fetches videos from URLs. It checks the address of every
+// connection and redirect, so a host can't reach the server's network by
+// changing its address after Validate.
+var downloadClient = safeurl.Client(30 * time.Minute)
+
+// inputPath returns where ffmpeg reads the video from, writing an inline
+// video to a temporary file. A video at a URL is downloaded first rather
+// than opened by ffmpeg, which would resolve the host and follow redirects
+// itself. The URL must point to a public address, or to a host in
+// safeurl.AllowedHosts.
+func (handler *EndPointHandler) inputPath(ctx context.Context) (string, error) {
+	if handler.VideoPath != "" {
+		return handler.VideoPath, nil
+	}
+	if handler.InputVideo != nil {
+		return handler.temps.write(handler.InputVideo)
+	}
+	if err := safeurl.Check(ctx, handler.InputVideoURL); err != nil {
+		return "", badRequest("invalid inputVideoUrl %q: %v", handler.InputVideoURL, err)
+	}
+	return handler.download(ctx)
 }
 
-func (handler *EndPointHandler) Read() (vid *renderer.VidoePayload, err error) {
-	w, h, err := renderer.FFmpegGetVideoDimensions(handler.InputVideo)
+// download fetches InputVideoURL into a temporary file.
+func (handler *EndPointHandler) download(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, handler.InputVideoURL, nil)
+	if err != nil {
+		return "", badRequest("invalid inputVideoUrl %q: %v", handler.InputVideoURL, err)
+	}
+	res, err := downloadClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get video dimensions: %v", err)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", badRequest("cannot fetch inputVideoUrl: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", badRequest("cannot fetch inputVideoUrl: server replied %s", res.Status)
+	}
+	tooLarge := &RequestError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("video at inputVideoUrl is larger than %d bytes", DownloadLimit),
 	}
+	if res.ContentLength > DownloadLimit {
+		return "", tooLarge
+	}
+	path, err := handler.temps.copy(&limitReader{r: res.Body, n: DownloadLimit, name: "inputVideoUrl", max: DownloadLimit})
+	var partErr *partTooLargeError
+	switch {
+	case errors.As(err, &partErr):
+		return "", tooLarge
+	case err != nil && ctx.Err() != nil:
+		return "", ctx.Err()
+	case err != nil:
+		return "", badRequest("cannot fetch inputVideoUrl: %v", err)
+	}
+	return path, nil
+}
+
+// configData returns the config text. A config sent as a JSON string holds
+// JSON or YAML text; anything else is used as is.
+func (handler *EndPointHandler) configData() []byte {
+	var text string
+	if err := json.Unmarshal(handler.Config, &text); err == nil {
+		return []byte(text)
+	}
+	return handler.Config
+}
+
+// options decodes Config over the defaults and applies the request-level
+// overrides.
+func (handler *EndPointHandler) options() (types.SubtitlesOptions, error) {
 	opts := DefaultOptions
-	opts.Width = w
-	opts.Height = h
+	if config := handler.configData(); len(config) > 0 {
+		var err error
+		if opts, err = DecodeConfig(config); err != nil {
+			return opts, err
+		}
+	}
+	if handler.Timing != nil {
+		opts.Timing = *handler.Timing
+	}
+	if handler.Encoding != nil {
+		opts.Encoding = *handler.Encoding
+	}
+	if handler.MusicGain != nil {
+		opts.MusicGain = *handler.MusicGain
+	}
+	return opts, ValidateOptions(opts)
+}
 
-	words, err := utils.ReadAndConvertToFrames(handler.Transcript, opts.FPS)
+// Validate checks the config and the transcript before any work is done.
+// ctx bounds the DNS lookups of the URLs.
+func (handler *EndPointHandler) Validate(ctx context.Context) error {
+	if handler.InputVideo == nil && handler.InputVideoURL == "" && handler.VideoPath == "" {
+		return badRequest("missing inputVideo")
+	}
+	if handler.InputVideo == nil && handler.VideoPath == "" {
+		if err := safeurl.Check(ctx, handler.InputVideoURL); err != nil {
+			return badRequest("invalid inputVideoUrl %q: %v", handler.InputVideoURL, err)
+		}
+	}
+	if len(handler.Transcript) == 0 {
+		return badRequest("missing transcript")
+	}
+	if !json.Valid(handler.Transcript) {
+		return badRequest("transcript is not valid JSON")
+	}
+	if handler.CallbackURL != "" {
+		if err := safeurl.Check(ctx, handler.CallbackURL); err != nil {
+			return badRequest("invalid callbackUrl %q: %v", handler.CallbackURL, err)
+		}
+	}
+	if _, err := handler.options(); err != nil {
+		return badRequest("%v", err)
+	}
+	return nil
+}
+
+func (handler *EndPointHandler) Read(ctx context.Context) (vid *renderer.VidoePayload, err error) {
+	opts, err := handler.options()
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+
+	input, err := handler.inputPath(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse json object from body")
+		return nil, err
+	}
+	var audio, music string
+	if handler.Audio != nil {
+		if audio, err = handler.temps.write(handler.Audio); err != nil {
+			return nil, fmt.Errorf("cannot store audio: %v", err)
+		}
+	}
+	if handler.Music != nil {
+		if music, err = handler.temps.write(handler.Music); err != nil {
+			return nil, fmt.Errorf("cannot store music: %v", err)
+		}
+	}
+
+	meta, err := renderer.FFprobeMetadata(ctx, input)
+	if err != nil {
+		return nil, badRequest("failed to probe video: %v", err)
+	}
+	meta.Apply(&opts)
+
+	words, err := utils.ReadAndConvertToFrames(handler.Transcript, opts)
+	if err != nil {
+		return nil, badRequest("cannot parse transcript: %v", err)
 	}
 
 	vid = &renderer.VidoePayload{
-		InputVideo: handler.InputVideo,
-		Words:      words,
-		Opts:       opts,
+		InputPath:            input,
+		Words:                words,
+		Opts:                 opts,
+		Metadata:             *meta,
+		ReplacementAudioPath: audio,
+		BackgroundMusicPath:  music,
 	}
 
 	return vid, nil
 
 }
 
-func (handler *EndPointHandler) SaveVideo(b []byte) error {
-	handler.Out = b
-	return nil
+// VideoWriter streams the video to Out, usually the HTTP response.
+func (handler *EndPointHandler) VideoWriter(ctx context.Context, contentType string) (io.WriteCloser, error) {
+	if handler.Out == nil {
+		return nil, fmt.Errorf("no output writer set")
+	}
+	return nopWriteCloser{handler.Out}, nil
+}
+
+func (handler *EndPointHandler) Close() error {
+	return handler.temps.removeAll()
 }
@@ -1,12 +1,12 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"google.golang.org/api/option"
 
@@ -18,8 +18,7 @@ import (
 	"github.com/elweday/go-subtitles/pkg/types"
 )
 
-func TranscibeFromStorage(client *speech.Client, gcsURI string) ([]types.Word, error) {
-	ctx := context.Background()
+func TranscibeFromStorage(ctx context.Context, client *speech.Client, gcsURI string) ([]types.Word, error) {
 
 	req := &speechpb.LongRunningRecognizeRequest{
 		Config: &speechpb.RecognitionConfig{
@@ -53,6 +52,7 @@ func TranscibeFromStorage(client *speech.Client, gcsURI string) ([]types.Word, e
 				Value:    w.Word,
 				Time:     start,
 				Duration: duration,
+				Speaker:  w.GetSpeakerLabel(),
 			}
 			words = append(words, w)
 		}
@@ -60,6 +60,9 @@ func TranscibeFromStorage(client *speech.Client, gcsURI string) ([]types.Word, e
 	return words, nil
 }
 
+// GcpIOHandler renders the request stored in the Firestore document Doc,
+// reading and writing the videos in the bucket BucketName and reporting
+// progress to the status field of the document.
 type GcpIOHandler struct {
 	BucketName   string `json:"bucketName"`
 	Doc          string `json:"doc"`
@@ -67,73 +70,127 @@ type GcpIOHandler struct {
 	CREDS        []byte `json:"creds"`
 	InputObject  string
 	OutputObject string
+
+	temps tempFiles
 }
 
-func (handler *GcpIOHandler) Auth() option.ClientOption {
-	return option.WithCredentialsJSON(handler.CREDS)
+// Auth returns the credentials sent with the request. The credentials of
+// the instance are never used, as they would let any caller reach whatever
+// the instance can.
+func (handler *GcpIOHandler) Auth() []option.ClientOption {
+	return []option.ClientOption{option.WithCredentialsJSON(handler.CREDS)}
 }
 
-func (handler *GcpIOHandler) SaveVideo(b []byte) error {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx, handler.Auth())
+// Validate checks that the request names a document and a bucket, and
+// carries the credentials to reach them.
+func (handler *GcpIOHandler) Validate() error {
+	switch {
+	case len(handler.CREDS) == 0:
+		return badRequest("missing creds")
+	case handler.ProjectID == "":
+		return badRequest("missing projectID")
+	case handler.Doc == "":
+		return badRequest("missing doc")
+	case handler.BucketName == "":
+		return badRequest("missing bucketName")
+	}
+	return nil
+}
+
+// VideoWriter uploads the video to OutputObject as it is written. The
+// object is only created when the writer is closed with ctx still live.
+func (handler *GcpIOHandler) VideoWriter(ctx context.Context, contentType string) (io.WriteCloser, error) {
+	client, err := storage.NewClient(ctx, handler.Auth()...)
 	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
+		return nil, fmt.Errorf("failed to create client: %v", err)
 	}
-	defer client.Close()
 
-	reader := bytes.NewReader(b)
 	// Get Google Cloud Storage bucket
 	bucket := client.Bucket(handler.BucketName)
 
 	// Create new object
 	obj := bucket.Object(handler.OutputObject)
-
-	// Write content of local file to GCS object
 	wc := obj.NewWriter(ctx)
-	if _, err := io.Copy(wc, reader); err != nil {
-		return fmt.Errorf("failed to write to object: %v", err)
-	}
-	if err := wc.Close(); err != nil {
+	wc.ContentType = contentType
+	return &gcsWriter{Writer: wc, client: client, handler: handler}, nil
+}
+
+// gcsWriter closes the storage client along with the object writer.
+type gcsWriter struct {
+	*storage.Writer
+	client  *storage.Client
+	handler *GcpIOHandler
+}
+
+func (w *gcsWriter) Close() error {
+	defer w.client.Close()
+	if err := w.Writer.Close(); err != nil {
 		return fmt.Errorf("failed to close writer: %v", err)
 	}
-
-	log.Printf("File uploaded to gs://%s/%s\n", handler.BucketName, handler.OutputObject)
+	log.Printf("File uploaded to gs://%s/%s\n", w.handler.BucketName, w.handler.OutputObject)
 	return nil
 }
 
-func (handler *GcpIOHandler) ReadInput() ([]byte, error) {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx, handler.Auth())
+func (handler *GcpIOHandler) Close() error {
+	return handler.temps.removeAll()
+}
+
+// statusInterval is the shortest time between two writes of the status
+// field, which stays under the Firestore limit of one write per second per
+// document.
+const statusInterval = time.Second
+
+// ReportProgress writes render progress to the status field of the document.
+func (handler *GcpIOHandler) ReportProgress(ctx context.Context) (renderer.ProgressFunc, func()) {
+	client, err := firestore.NewClient(ctx, handler.ProjectID, handler.Auth()...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create client: %v", err)
+		log.Printf("progress will not be reported: %v\n", err)
+		return nil, func() {}
+	}
+	doc := client.Doc(handler.Doc)
+
+	var last time.Time
+	report := func(p renderer.Progress) {
+		if p.Stage != renderer.StageDone && time.Since(last) < statusInterval {
+			return
+		}
+		last = time.Now()
+		if _, err := doc.Update(ctx, []firestore.Update{{Path: "status", Value: p}}); err != nil {
+			log.Printf("failed to update status: %v\n", err)
+		}
+	}
+	return report, func() { client.Close() }
+}
+
+// ReadInput downloads InputObject to a temporary file and returns its path.
+func (handler *GcpIOHandler) ReadInput(ctx context.Context) (string, error) {
+	client, err := storage.NewClient(ctx, handler.Auth()...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create client: %v", err)
 	}
 	defer client.Close()
 
-	objBytes := []byte{}
-	inReader := bytes.NewBuffer(objBytes)
 	// Get Google Cloud Storage bucket
 	bucket := client.Bucket(handler.BucketName)
 
 	obj := bucket.Object(handler.InputObject)
 	storageReader, err := obj.NewReader(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create newReader: %v", err)
+		return "", fmt.Errorf("failed to create newReader: %v", err)
 	}
+	defer storageReader.Close()
 
-	if _, err := io.Copy(inReader, storageReader); err != nil {
-		return nil, fmt.Errorf("failed to write to object: %v", err)
-	}
-	if err := storageReader.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close writer: %v", err)
+	path, err := handler.temps.copy(storageReader)
+	if err != nil {
+		return "", fmt.Errorf("failed to download object: %v", err)
 	}
 
 	log.Printf("File read from gs://%s/%s\n", handler.BucketName, handler.InputObject)
-	return inReader.Bytes(), nil
+	return path, nil
 }
 
-func (handler *GcpIOHandler) Read() (vid *renderer.VidoePayload, err error) {
-	ctx := context.Background()
-	client, err := firestore.NewClient(ctx, handler.ProjectID, handler.Auth())
+func (handler *GcpIOHandler) Read(ctx context.Context) (vid *renderer.VidoePayload, err error) {
+	client, err := firestore.NewClient(ctx, handler.ProjectID, handler.Auth()...)
 	if err != nil {
 		return vid, fmt.Errorf("failed to create client: %v", err)
 	}
@@ -146,21 +203,28 @@ func (handler *GcpIOHandler) Read() (vid *renderer.VidoePayload, err error) {
 		return nil, fmt.Errorf("failed to get document: %v", err)
 	}
 
+	// fields missing from the document keep their defaults
+	vid = &renderer.VidoePayload{Opts: DefaultOptions}
 	if err := docsnap.DataTo(vid); err != nil {
 		return nil, fmt.Errorf("failed to decode document: %v", err)
 	}
+	if err := ValidateOptions(vid.Opts); err != nil {
+		return nil, err
+	}
 	log.Printf("Document read from firestore: %s\n", handler.Doc)
 
 	handler.InputObject = vid.InputVideoObj
 	handler.OutputObject = vid.OutputVideoObj
-	videoBytes, err := handler.ReadInput()
-	vid.InputVideo = videoBytes
+	vid.InputPath, err = handler.ReadInput(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read video: %v", err)
 	}
-	w, h, err := renderer.FFmpegGetVideoDimensions(videoBytes)
-	vid.Opts.Width = w
-	vid.Opts.Height = h
+	meta, err := renderer.FFprobeMetadata(ctx, vid.InputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe video: %v", err)
+	}
+	meta.Apply(&vid.Opts)
+	vid.Metadata = *meta
 
 	return vid, nil
 }
@@ -170,9 +234,10 @@ func Test() {
 	b := []byte(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
 	// TODO: Change authenrication method
 	creds := option.WithCredentialsJSON(b)
-	client, _ := speech.NewClient(context.Background(), creds)
+	ctx := context.Background()
+	client, _ := speech.NewClient(ctx, creds)
 
-	words, _ := TranscibeFromStorage(client, "gs://subtitles-demos/1.mp3")
+	words, _ := TranscibeFromStorage(ctx, client, "gs://subtitles-demos/1.mp3")
 
 	fmt.Println(words)
 }
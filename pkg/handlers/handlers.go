@@ -1,13 +1,104 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/elweday/go-subtitles/pkg/renderer"
 	"github.com/elweday/go-subtitles/pkg/types"
+	"github.com/elweday/go-subtitles/pkg/utils"
 )
 
 type IOHandler interface {
-	Read() (vid *renderer.VidoePayload, err error)
-	SaveVideo(b []byte) error
+	Read(ctx context.Context) (vid *renderer.VidoePayload, err error)
+	// VideoWriter returns where the rendered video is streamed to. Cancelling
+	// ctx before closing the writer discards the output where possible.
+	VideoWriter(ctx context.Context, contentType string) (io.WriteCloser, error)
+	// Close removes the temporary files made by Read.
+	Close() error
+}
+
+// ProgressReporter is implemented by handlers that publish render progress.
+// The returned function releases what the reporter holds once the render is
+// over.
+type ProgressReporter interface {
+	ReportProgress(ctx context.Context) (renderer.ProgressFunc, func())
+}
+
+// Render reads the request of handler, renders it and writes the video to
+// the writer of handler, reporting progress if handler is a
+// ProgressReporter.
+func Render(ctx context.Context, handler IOHandler) error {
+	defer handler.Close()
+	vid, err := handler.Read(ctx)
+	if err != nil {
+		return err
+	}
+	if reporter, ok := handler.(ProgressReporter); ok {
+		report, stop := reporter.ReportProgress(ctx)
+		defer stop()
+		vid.Progress = report
+	}
+
+	// cancelling the writer's context discards a partly written video
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out, err := handler.VideoWriter(writeCtx, vid.ContentType())
+	if err != nil {
+		return err
+	}
+	vid.Output = out
+	err = vid.RenderWithSubtitles(ctx)
+	if err != nil {
+		cancel()
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// RequestError is an error caused by the request rather than the server.
+type RequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, a ...any) *RequestError {
+	return &RequestError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, a...)}
+}
+
+// ErrorStatus returns the HTTP status for an error returned by a handler.
+func ErrorStatus(err error) int {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// OutputFilename names the rendered copy of an uploaded video.
+func OutputFilename(videoName, extension string) string {
+	return baseName(videoName) + "-subtitled" + extension
+}
+
+// baseName returns the name of an uploaded video without its extension.
+func baseName(videoName string) string {
+	name := strings.TrimSuffix(videoName, filepath.Ext(videoName))
+	if name == "" || name == "." {
+		name = "video"
+	}
+	return name
 }
 
 var DefaultOptions = types.SubtitlesOptions{
@@ -31,4 +122,54 @@ var DefaultOptions = types.SubtitlesOptions{
 	FPS:                   30,
 	Center:                true,
 	Alignment:             "center",
+	PopFontSize:           90,
+	PopMaxWidth:           0.8,
+	PopSpring:             types.SpringOptions{Stiffness: 300, Damping: 18, Mass: 1},
+	EmphasizeNumbers:      false,
+	EmphasisStyle:         types.WordStyle{Color: "ffd400", Bold: true, Scale: 1.15},
+	GapThreshold:          1.5,
+	GapFade:               0.25,
+	PhraseBreak:           "page",
+	MusicGain:             -12,
+}
+
+// tempFiles tracks the temporary files a handler has to remove.
+type tempFiles []string
+
+// write stores data in a new temporary file and returns its path.
+func (t *tempFiles) write(data []byte) (string, error) {
+	f, err := utils.WriteTemp(data)
+	if err != nil {
+		return "", err
+	}
+	*t = append(*t, f.Name())
+	return f.Name(), nil
 }
+
+// copy streams r to a new temporary file and returns its path.
+func (t *tempFiles) copy(r io.Reader) (string, error) {
+	f, err := utils.CopyTemp(r)
+	if err != nil {
+		return "", err
+	}
+	*t = append(*t, f.Name())
+	return f.Name(), nil
+}
+
+func (t *tempFiles) removeAll() error {
+	var err error
+	for _, name := range *t {
+		if e := os.Remove(name); e != nil && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+	*t = nil
+	return err
+}
+
+// nopWriteCloser adds a no-op Close to writers the handler does not own.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -1,55 +1,146 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/elweday/go-subtitles/pkg/renderer"
+	"github.com/elweday/go-subtitles/pkg/transcript"
+	"github.com/elweday/go-subtitles/pkg/types"
 	"github.com/elweday/go-subtitles/pkg/utils"
 )
 
+// LocalIOHandler renders a video on disk into OutputPath. The transcript may
+// be a JSON word list, SRT or WebVTT, picked by its extension.
 type LocalIOHandler struct {
 	InputVideoPath string
 	TranscriptPath string
 	ConfigPath     string
+	Config         []byte // JSON or YAML config, used instead of ConfigPath when set
 	OutputPath     string
+	Style          string
+	Timing         *types.Timing
+	AudioPath      string
+	MusicPath      string
+	Encoding       *types.Encoding
+	Progress       renderer.ProgressFunc
 }
 
-func (handler *LocalIOHandler) Read() (vid *renderer.VidoePayload, err error) {
+// Options decodes the config over the defaults and applies the overrides.
+func (handler *LocalIOHandler) Options() (types.SubtitlesOptions, error) {
+	opts := DefaultOptions
+	config := handler.Config
+	if config == nil && handler.ConfigPath != "" {
+		var err error
+		if config, err = os.ReadFile(handler.ConfigPath); err != nil {
+			return opts, fmt.Errorf("cannot read file %s", handler.ConfigPath)
+		}
+	}
+	if config != nil {
+		var err error
+		if opts, err = DecodeConfig(config); err != nil {
+			if handler.ConfigPath != "" {
+				return opts, fmt.Errorf("file %s: %v", handler.ConfigPath, err)
+			}
+			return opts, err
+		}
+	}
+	if handler.Style != "" {
+		opts.Style = handler.Style
+	}
+	if handler.Timing != nil {
+		opts.Timing = *handler.Timing
+	}
+	if handler.Encoding != nil {
+		opts.Encoding = *handler.Encoding
+	}
+	return opts, ValidateOptions(opts)
+}
 
-	inputVideo, err := os.ReadFile(handler.InputVideoPath)
+// Words reads the transcript and times it to the frames of opts.
+func (handler *LocalIOHandler) Words(opts types.SubtitlesOptions) ([]types.Word, error) {
+	words, err := transcript.ReadFile(handler.TranscriptPath)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read file %s", handler.InputVideoPath)
+		return nil, fmt.Errorf("cannot read transcript: %v", err)
 	}
+	data, err := transcript.Marshal(words)
+	if err != nil {
+		return nil, err
+	}
+	words, err = utils.ReadAndConvertToFrames(data, opts)
+	if err != nil {
+		return nil, fmt.Errorf("file %s does not follow the correct format: %v", handler.TranscriptPath, err)
+	}
+	return words, nil
+}
+
+func (handler *LocalIOHandler) Read(ctx context.Context) (vid *renderer.VidoePayload, err error) {
 
-	w, h, err := renderer.FFmpegGetVideoDimensions(inputVideo)
+	for _, path := range []string{handler.InputVideoPath, handler.AudioPath, handler.MusicPath} {
+		if _, err := os.Stat(path); path != "" && err != nil {
+			return nil, fmt.Errorf("cannot read file %s", path)
+		}
+	}
+
+	opts, err := handler.Options()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get video dimensions: %v", err)
+		return nil, err
 	}
-	opts := DefaultOptions
-	opts.Width = w
-	opts.Height = h
 
-	transcriptBytes, err := os.ReadFile(handler.TranscriptPath)
+	meta, err := renderer.FFprobeMetadata(ctx, handler.InputVideoPath)
 	if err != nil {
-		return nil, fmt.Errorf("file %s does not exist, make sure you set SUBTITLES_TRANSCRIPT_PATH environment variable to a json file that follows the correct format", handler.TranscriptPath)
+		return nil, fmt.Errorf("failed to probe video: %v", err)
 	}
+	meta.Apply(&opts)
 
-	words, err := utils.ReadAndConvertToFrames(transcriptBytes, opts.FPS)
+	words, err := handler.Words(opts)
 	if err != nil {
-		return nil, fmt.Errorf("file %s does not follow the correct format", handler.TranscriptPath)
+		return nil, err
 	}
 
 	vid = &renderer.VidoePayload{
-		InputVideo: inputVideo,
-		Words:      words,
-		Opts:       opts,
+		InputPath:            handler.InputVideoPath,
+		Words:                words,
+		Opts:                 opts,
+		Metadata:             *meta,
+		ReplacementAudioPath: handler.AudioPath,
+		BackgroundMusicPath:  handler.MusicPath,
 	}
 
 	return vid, nil
 
 }
 
-func (handler *LocalIOHandler) SaveVideo(b []byte) error {
-	return os.WriteFile(handler.OutputPath, b, 0644)
+// VideoWriter creates OutputPath, removing it again if ctx is cancelled
+// before the writer is closed.
+func (handler *LocalIOHandler) VideoWriter(ctx context.Context, contentType string) (io.WriteCloser, error) {
+	f, err := os.Create(handler.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: f, ctx: ctx}, nil
+}
+
+func (handler *LocalIOHandler) ReportProgress(ctx context.Context) (renderer.ProgressFunc, func()) {
+	return handler.Progress, func() {}
+}
+
+func (handler *LocalIOHandler) Close() error {
+	return nil
+}
+
+type fileWriter struct {
+	*os.File
+	ctx context.Context
+}
+
+func (w *fileWriter) Close() error {
+	err := w.File.Close()
+	if w.ctx.Err() != nil {
+		os.Remove(w.Name())
+		return w.ctx.Err()
+	}
+	return err
 }
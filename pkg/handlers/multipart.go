@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// UploadLimits caps the size of each part of a multipart render request.
+type UploadLimits struct {
+	Video      int64
+	Transcript int64
+	Config     int64
+}
+
+// DefaultUploadLimits suit a server whose temporary directory is on disk.
+var DefaultUploadLimits = UploadLimits{
+	Video:      2 << 30,
+	Transcript: 10 << 20,
+	Config:     1 << 20,
+}
+
+// Total is the largest body the limits allow, with room for the multipart
+// boundaries and headers.
+func (l UploadLimits) Total() int64 {
+	return l.Video + l.Transcript + l.Config + 64<<10
+}
+
+// ReadMultipart reads a multipart/form-data render request with a "video"
+// file part, a "transcript" part and optional "config" and "callbackUrl"
+// parts. The video is
+// streamed to a temporary file that the returned handler removes on Close.
+func ReadMultipart(r *http.Request, limits UploadLimits) (handler *EndPointHandler, err error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, badRequest("expected a multipart/form-data body: %v", err)
+	}
+
+	handler = &EndPointHandler{}
+	// remove the uploaded video when the request turns out to be invalid
+	defer func(h *EndPointHandler) {
+		if err != nil {
+			h.Close()
+		}
+	}(handler)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, readError(err)
+		}
+
+		switch part.FormName() {
+		case "video":
+			if handler.VideoPath != "" {
+				return nil, badRequest("more than one video part")
+			}
+			handler.VideoPath, err = handler.temps.copy(limitPart(part, limits.Video))
+			handler.VideoName = filepath.Base(part.FileName())
+		case "transcript":
+			handler.Transcript, err = io.ReadAll(limitPart(part, limits.Transcript))
+		case "config":
+			handler.Config, err = io.ReadAll(limitPart(part, limits.Config))
+		case "callbackUrl":
+			var data []byte
+			data, err = io.ReadAll(limitPart(part, limits.Config))
+			handler.CallbackURL = strings.TrimSpace(string(data))
+		default:
+			err = badRequest("unexpected part %q, expected video, transcript, config or callbackUrl", part.FormName())
+		}
+		part.Close()
+		if err != nil {
+			return nil, readError(err)
+		}
+	}
+
+	if handler.VideoPath == "" {
+		return nil, badRequest("missing video part")
+	}
+	if err := handler.Validate(r.Context()); err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// partTooLargeError is returned by a part reader past its size limit.
+type partTooLargeError struct {
+	name  string
+	limit int64
+}
+
+func (e *partTooLargeError) Error() string {
+	return fmt.Sprintf("%s part is larger than %d bytes", e.name, e.limit)
+}
+
+// limitReader fails with partTooLargeError once more than max bytes are
+// read.
+type limitReader struct {
+	r    io.Reader
+	n    int64 // bytes left
+	name string
+	max  int64
+}
+
+func limitPart(part *multipart.Part, max int64) io.Reader {
+	return &limitReader{r: part, n: max, name: part.FormName(), max: max}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, &partTooLargeError{l.name, l.max}
+	}
+	// read one byte past the limit to tell a full part from a larger one
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, &partTooLargeError{l.name, l.max}
+	}
+	return n, err
+}
+
+// readError maps the errors of reading a request body to request errors.
+func readError(err error) error {
+	var tooLarge *partTooLargeError
+	var maxBytes *http.MaxBytesError
+	var reqErr *RequestError
+	switch {
+	case errors.As(err, &tooLarge):
+		return &RequestError{Status: http.StatusRequestEntityTooLarge, Message: tooLarge.Error()}
+	case errors.As(err, &maxBytes):
+		return &RequestError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("request body is larger than %d bytes", maxBytes.Limit)}
+	case errors.As(err, &reqErr):
+		return err
+	case strings.Contains(err.Error(), "multipart"):
+		return badRequest("malformed multipart body: %v", err)
+	}
+	return fmt.Errorf("failed to read request body: %v", err)
+}
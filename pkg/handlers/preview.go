@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/elweday/go-subtitles/pkg/renderer"
+)
+
+// MaxPreviewFrames is the most frames a contact sheet may tile.
+const MaxPreviewFrames = 64
+
+// MaxTileWidth is the widest a contact sheet tile may be.
+const MaxTileWidth = 1920
+
+// PreviewRequest picks the frames of a preview. A single time gives that
+// frame at full size, anything else gives a contact sheet.
+type PreviewRequest struct {
+	Times     []float64 // seconds into the video
+	Count     int       // timestamps spread over the captions when Times is empty
+	Columns   int       // tiles per row of a contact sheet, 0 for a square grid
+	TileWidth int       // width of a contact sheet tile, 0 for the default
+}
+
+// Sheet reports whether the request is for a contact sheet.
+func (req PreviewRequest) Sheet() bool {
+	return len(req.Times) != 1 || req.Count > 0
+}
+
+// Validate checks the request before the video is read.
+func (req PreviewRequest) Validate() error {
+	n := len(req.Times)
+	if n == 0 {
+		n = req.Count
+	}
+	switch {
+	case n <= 0:
+		return badRequest("preview needs a timestamp or a frame count")
+	case len(req.Times) > 0 && req.Count > 0:
+		return badRequest("preview takes timestamps or a frame count, not both")
+	case n > MaxPreviewFrames:
+		return badRequest("preview has %d frames, at most %d are allowed", n, MaxPreviewFrames)
+	case req.Columns < 0 || req.TileWidth < 0:
+		return badRequest("columns and tile width can't be negative")
+	case req.TileWidth > MaxTileWidth:
+		return badRequest("tile width %d is over %d", req.TileWidth, MaxTileWidth)
+	}
+	for _, t := range req.Times {
+		if t < 0 {
+			return badRequest("timestamp %g is negative", t)
+		}
+	}
+	return nil
+}
+
+// ParsePreviewQuery reads a PreviewRequest from the query parameters "t"
+// (repeatable or comma separated timestamps), "count", "columns" and
+// "width".
+func ParsePreviewQuery(query url.Values) (PreviewRequest, error) {
+	var req PreviewRequest
+	for _, v := range query["t"] {
+		times, err := ParseTimes(v)
+		if err != nil {
+			return req, badRequest("t: %v", err)
+		}
+		req.Times = append(req.Times, times...)
+	}
+	for name, n := range map[string]*int{"count": &req.Count, "columns": &req.Columns, "width": &req.TileWidth} {
+		if v := query.Get(name); v != "" {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil {
+				return req, badRequest("%s: %q is not a number", name, v)
+			}
+		}
+	}
+	return req, req.Validate()
+}
+
+// ParseTimes reads comma separated timestamps, each in seconds or as
+// [h:]m:s, e.g. "3.5,1:02.25".
+func ParseTimes(s string) ([]float64, error) {
+	var times []float64
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		t, err := parseTime(field)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+func parseTime(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	t := 0.0
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		// only the seconds may have a fraction
+		if err != nil || !(v >= 0) || math.IsInf(v, 0) || (i < len(parts)-1 && v != math.Trunc(v)) {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		t = t*60 + v
+	}
+	return t, nil
+}
+
+// PreviewFilename names the preview image of an uploaded video.
+func PreviewFilename(videoName string) string {
+	return baseName(videoName) + "-preview.png"
+}
+
+// Preview reads the request of handler and returns a PNG of the captions
+// over the video frames picked by req, without rendering the video.
+func Preview(ctx context.Context, handler IOHandler, req PreviewRequest) ([]byte, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	defer handler.Close()
+	vid, err := handler.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	times := req.Times
+	if len(times) == 0 {
+		times = vid.SheetTimes(req.Count)
+	}
+	for _, t := range times {
+		if vid.Opts.Duration > 0 && t >= vid.Opts.Duration {
+			return nil, badRequest("timestamp %g is past the end of the %.3fs video", t, vid.Opts.Duration)
+		}
+	}
+
+	var img image.Image
+	if req.Sheet() {
+		size := vid.SheetSize(len(times), req.Columns, req.TileWidth)
+		if size.X*size.Y > renderer.MaxSheetPixels {
+			return nil, badRequest("contact sheet of %dx%d pixels is too large, use fewer frames or narrower tiles", size.X, size.Y)
+		}
+		img, err = vid.ContactSheet(ctx, times, req.Columns, req.TileWidth)
+	} else {
+		img, err = vid.Preview(ctx, times[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
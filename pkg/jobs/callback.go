@@ -0,0 +1,157 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elweday/go-subtitles/pkg/safeurl"
+)
+
+// Headers of a callback request. The signature is "sha256=" followed by the
+// hex HMAC-SHA256 of the timestamp, a dot and the body.
+const (
+	SignatureHeader = "X-Subtitles-Signature"
+	TimestampHeader = "X-Subtitles-Timestamp"
+)
+
+// Notification is the body POSTed to the callbackUrl of a finished job.
+type Notification struct {
+	JobID    string  `json:"jobId"`
+	Status   State   `json:"status"`
+	Output   string  `json:"output,omitempty"` // URL of the result, when succeeded
+	Duration float64 `json:"duration"`         // seconds from start to finish
+	Error    string  `json:"error,omitempty"`
+	Attempt  int     `json:"attempt,omitempty"` // delivery attempt, from 1
+}
+
+// DefaultMaxSkew is how far the timestamp of a callback may be from the
+// clock of the receiver before Verify rejects it.
+const DefaultMaxSkew = 5 * time.Minute
+
+// Notifier delivers notifications, retrying failed deliveries with
+// exponential backoff.
+type Notifier struct {
+	Client      *http.Client  // a client that only reaches public addresses when nil
+	Secret      []byte        // HMAC key, requests are unsigned when empty
+	MaxAttempts int           // deliveries tried before giving up
+	Backoff     time.Duration // delay before the first retry, doubled after each
+	MaxBackoff  time.Duration
+}
+
+// DefaultNotifier tries a callback 6 times over about 30 seconds.
+var DefaultNotifier = Notifier{
+	Client:      safeurl.Client(10 * time.Second),
+	MaxAttempts: 6,
+	Backoff:     time.Second,
+	MaxBackoff:  time.Minute,
+}
+
+// Sign returns the signature header value for a body sent at timestamp.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a callback request whose body has been
+// read into body, and that it was sent within maxSkew of now so a captured
+// request can't be replayed later. A maxSkew of 0 uses DefaultMaxSkew.
+func Verify(secret []byte, r *http.Request, body []byte, maxSkew time.Duration) bool {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(sent, 0)); skew > maxSkew || skew < -maxSkew {
+		return false
+	}
+	expected := Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get(SignatureHeader)))
+}
+
+// Notify POSTs note to url until it is accepted with a 2xx status, a 4xx
+// status other than 408 and 429 is returned, the attempts run out or ctx
+// ends.
+func (n *Notifier) Notify(ctx context.Context, url string, note Notification) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		note.Attempt = attempt
+		var retry bool
+		retry, err = n.send(ctx, url, note)
+		if err == nil || !retry || attempt >= n.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v, giving up: %v", err, ctx.Err())
+		case <-time.After(n.backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns the delay after the given failed attempt: Backoff after
+// the first, doubled after each further one, and at most MaxBackoff.
+func (n *Notifier) backoff(attempt int) time.Duration {
+	delay := n.Backoff
+	for i := 1; i < attempt && (n.MaxBackoff <= 0 || delay < n.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if n.MaxBackoff > 0 && delay > n.MaxBackoff {
+		delay = n.MaxBackoff
+	}
+	return delay
+}
+
+var defaultClient = safeurl.Client(10 * time.Second)
+
+// send makes one delivery and reports whether a failure is worth retrying.
+func (n *Notifier) send(ctx context.Context, url string, note Notification) (retry bool, err error) {
+	body, err := json.Marshal(note)
+	if err != nil {
+		return false, fmt.Errorf("cannot encode notification: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("invalid callback request: %v", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	if len(n.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.Secret, timestamp, body))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = defaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("callback failed: %v", err)
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("callback returned %s", res.Status)
+	}
+	return false, fmt.Errorf("callback returned %s", res.Status)
+}
@@ -0,0 +1,159 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a callback endpoint answering with the given statuses in turn,
+// then with 200.
+type receiver struct {
+	t        *testing.T
+	secret   []byte
+	statuses []int
+
+	mu    sync.Mutex
+	notes []Notification
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("reading body: %v", err)
+	}
+	if !Verify(rc.secret, r, body, 0) {
+		rc.t.Errorf("signature of %s does not verify", body)
+	}
+	var note Notification
+	if err := json.Unmarshal(body, &note); err != nil {
+		rc.t.Errorf("decoding %s: %v", body, err)
+	}
+
+	rc.mu.Lock()
+	rc.notes = append(rc.notes, note)
+	status := http.StatusOK
+	if n := len(rc.notes); n <= len(rc.statuses) {
+		status = rc.statuses[n-1]
+	}
+	rc.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		attempts int
+	}{
+		{"accepted", nil, false, 1},
+		{"retries server errors", []int{500, 503}, false, 3},
+		{"retries too many requests", []int{429}, false, 2},
+		{"retries request timeout", []int{408}, false, 2},
+		{"gives up on client errors", []int{400}, true, 1},
+		{"gives up on not found", []int{404}, true, 1},
+		{"runs out of attempts", []int{500, 500, 500, 500}, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{t: t, secret: []byte("secret"), statuses: tt.statuses}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+
+			n := &Notifier{Client: srv.Client(), Secret: rc.secret, MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+			err := n.Notify(context.Background(), srv.URL, Notification{JobID: "job", Status: StateSucceeded})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, want error %t", err, tt.wantErr)
+			}
+			if len(rc.notes) != tt.attempts {
+				t.Fatalf("got %d deliveries, want %d", len(rc.notes), tt.attempts)
+			}
+			for i, note := range rc.notes {
+				if note.Attempt != i+1 || note.JobID != "job" || note.Status != StateSucceeded {
+					t.Errorf("delivery %d = %+v", i+1, note)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	n := &Notifier{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := n.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	n = &Notifier{Backoff: 2 * time.Second, MaxBackoff: time.Second}
+	if got := n.backoff(1); got != time.Second {
+		t.Errorf("backoff above MaxBackoff = %v, want %v", got, time.Second)
+	}
+	n = &Notifier{Backoff: time.Second}
+	if got := n.backoff(4); got != 8*time.Second {
+		t.Errorf("uncapped backoff(4) = %v, want %v", got, 8*time.Second)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"jobId":"job"}`)
+	request := func(sent time.Time, signature string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		timestamp := strconv.FormatInt(sent.Unix(), 10)
+		r.Header.Set(TimestampHeader, timestamp)
+		if signature == "" {
+			signature = Sign(secret, timestamp, body)
+		}
+		r.Header.Set(SignatureHeader, signature)
+		return r
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name string
+		r    *http.Request
+		body []byte
+		want bool
+	}{
+		{"valid", request(now, ""), body, true},
+		{"slightly early", request(now.Add(-time.Minute), ""), body, true},
+		{"replayed", request(now.Add(-time.Hour), ""), body, false},
+		{"from the future", request(now.Add(time.Hour), ""), body, false},
+		{"changed body", request(now, ""), []byte(`{"jobId":"other"}`), false},
+		{"wrong signature", request(now, "sha256=00"), body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(secret, tt.r, tt.body, 5*time.Minute); got != tt.want {
+				t.Errorf("Verify() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	r := request(now, "")
+	r.Header.Set(TimestampHeader, "yesterday")
+	if Verify(secret, r, body, 0) {
+		t.Error("Verify() accepted a malformed timestamp")
+	}
+}
+
+func TestDefaultClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("callback reached a loopback address")
+	}))
+	defer srv.Close()
+
+	n := &Notifier{MaxAttempts: 1}
+	if err := n.Notify(context.Background(), srv.URL, Notification{JobID: "job"}); err == nil {
+		t.Fatal("Notify() to a loopback address succeeded")
+	}
+}
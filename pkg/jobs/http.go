@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+)
+
+// ServeHTTP serves the job API under the last "jobs" segment of the path,
+// or under the root when there is none:
+//
+//	POST   /jobs             submit a JSON or multipart render request
+//	GET    /jobs/{id}        job state, progress and error
+//	GET    /jobs/{id}/result rendered video of a succeeded job
+//	DELETE /jobs/{id}        cancel a job, or remove a finished one
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base, parts := splitPath(r.URL.Path)
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		m.create(w, r, base)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		job, err := m.Get(parts[0])
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		job, err := m.Delete(parts[0])
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case len(parts) == 2 && parts[1] == "result" && r.Method == http.MethodGet:
+		m.result(w, r, parts[0])
+	case len(parts) <= 1 || (len(parts) == 2 && parts[1] == "result"):
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// splitPath returns the path up to and including the "jobs" segment and
+// the segments after it.
+func splitPath(path string) (base string, parts []string) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "", nil
+	}
+	segments := strings.Split(path, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] == "jobs" {
+			return "/" + strings.Join(segments[:i+1], "/"), segments[i+1:]
+		}
+	}
+	return "", segments
+}
+
+func (m *Manager) create(w http.ResponseWriter, r *http.Request, base string) {
+	r.Body = http.MaxBytesReader(w, r.Body, m.limits.Total())
+
+	var handler *handlers.EndPointHandler
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		handler, err = handlers.ReadMultipart(r, m.limits)
+	} else {
+		handler, err = decodeJSON(r)
+	}
+	if err != nil {
+		writeError(w, handlers.ErrorStatus(err), "invalid request: "+err.Error())
+		return
+	}
+	defer handler.Close()
+
+	job, err := m.Submit(handler, requestURL(r, base))
+	if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrClosed) {
+		w.Header().Set("Retry-After", "30")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", base+"/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// requestURL returns the absolute URL of path on the host r was sent to.
+func requestURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// decodeJSON reads a render request in the JSON body of r.
+func decodeJSON(r *http.Request) (*handlers.EndPointHandler, error) {
+	handler := &handlers.EndPointHandler{}
+	if err := json.NewDecoder(r.Body).Decode(handler); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return nil, &handlers.RequestError{
+				Status:  http.StatusRequestEntityTooLarge,
+				Message: fmt.Sprintf("request body is larger than %d bytes", maxBytes.Limit),
+			}
+		}
+		return nil, &handlers.RequestError{Status: http.StatusBadRequest, Message: "cannot parse body: " + err.Error()}
+	}
+	if err := handler.Validate(r.Context()); err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+func (m *Manager) result(w http.ResponseWriter, r *http.Request, id string) {
+	job, err := m.Get(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	if job.State != StateSucceeded {
+		writeJSON(w, http.StatusConflict, job)
+		return
+	}
+
+	f, err := os.Open(job.Output)
+	if err != nil {
+		writeError(w, http.StatusGone, "result is no longer available")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", job.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": handlers.OutputFilename(job.VideoName, filepath.Ext(job.Output)),
+	}))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,627 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/renderer"
+	"github.com/google/uuid"
+)
+
+// State is the stage of a job.
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Done reports whether a job in state s will not change any more.
+func (s State) Done() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled
+}
+
+// Job is the status of a render job.
+type Job struct {
+	ID          string                      `json:"id"`
+	State       State                       `json:"state"`
+	Progress    *renderer.Progress          `json:"progress,omitempty"`
+	Warnings    []renderer.LineBreakWarning `json:"warnings,omitempty"` // line-breaking rules the captions break
+	Error       string                      `json:"error,omitempty"`
+	ContentType string                      `json:"contentType,omitempty"`
+	CreatedAt   time.Time                   `json:"createdAt"`
+	StartedAt   *time.Time                  `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time                  `json:"finishedAt,omitempty"`
+	ResultURL   string                      `json:"resultUrl,omitempty"`
+	Attempts    int                         `json:"attempts"` // times the job was started
+	History     []Transition                `json:"history,omitempty"`
+	VideoName   string                      `json:"-"` // file name of the uploaded video
+	Output      string                      `json:"-"` // path of the rendered video
+	BaseURL     string                      `json:"-"` // URL the job was submitted to
+	CallbackURL string                      `json:"-"`
+}
+
+// Transition is a change of the state of a job.
+type Transition struct {
+	State State     `json:"state"`
+	At    time.Time `json:"at"`
+	Error string    `json:"error,omitempty"`
+}
+
+// setState moves job to state and records the transition.
+func (job *Job) setState(state State, at time.Time, reason string) {
+	job.State = state
+	job.History = append(job.History, Transition{State: state, At: at, Error: reason})
+}
+
+// Duration is the time the job ran for.
+func (job *Job) Duration() time.Duration {
+	if job.StartedAt == nil || job.FinishedAt == nil {
+		return 0
+	}
+	return job.FinishedAt.Sub(*job.StartedAt)
+}
+
+var (
+	ErrNotFound  = errors.New("job not found")
+	ErrQueueFull = errors.New("job queue is full")
+	ErrClosed    = errors.New("job manager is shutting down")
+)
+
+// inputFile is the video in the directory of each job.
+const inputFile = "input"
+
+// DefaultMaxAttempts is how many times a job interrupted by a restart is
+// started before it is failed.
+const DefaultMaxAttempts = 3
+
+// DefaultRetention is how long finished jobs and their files are kept.
+const DefaultRetention = 24 * time.Hour
+
+// maxSweepInterval is the longest time between two sweeps of finished jobs.
+const maxSweepInterval = time.Hour
+
+// Options configures a Manager.
+type Options struct {
+	Dir         string        // where the job store, inputs and outputs are kept
+	Workers     int           // renders running at once
+	QueueSize   int           // jobs waiting for a worker before Submit fails
+	MaxAttempts int           // starts of an interrupted job, DefaultMaxAttempts when 0
+	Retention   time.Duration // how long finished jobs are kept, DefaultRetention when 0, forever when negative
+	Limits      handlers.UploadLimits
+	Notifier    *Notifier // delivers callbacks, DefaultNotifier when nil
+}
+
+// Manager runs render jobs on a bounded pool of workers.
+type Manager struct {
+	dir         string
+	store       *store
+	maxAttempts int
+	retention   time.Duration
+	limits      handlers.UploadLimits
+	notifier    *Notifier
+	queue       chan string
+	quit        chan struct{}
+
+	// ctx is cancelled to stop running renders on a forced shutdown
+	ctx      context.Context
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+	notified sync.WaitGroup // callbacks being delivered
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	closed  bool
+}
+
+// NewManager opens the job store in opts.Dir, re-queues the jobs a previous
+// process left unfinished and starts the workers.
+func NewManager(opts Options) (*Manager, error) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize < 0 {
+		opts.QueueSize = 0
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Retention == 0 {
+		opts.Retention = DefaultRetention
+	}
+	if opts.Notifier == nil {
+		notifier := DefaultNotifier
+		opts.Notifier = &notifier
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create job directory: %v", err)
+	}
+	store, err := openStore(filepath.Join(opts.Dir, "jobs.db"))
+	if err != nil {
+		return nil, err
+	}
+	stored, err := store.load()
+	if err != nil {
+		store.close()
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	m := &Manager{
+		dir:         opts.Dir,
+		store:       store,
+		maxAttempts: opts.MaxAttempts,
+		retention:   opts.Retention,
+		limits:      opts.Limits,
+		notifier:    opts.Notifier,
+		// recovered jobs always fit in the queue
+		queue:   make(chan string, max(opts.QueueSize, len(stored))),
+		quit:    make(chan struct{}),
+		ctx:     ctx,
+		stop:    stop,
+		jobs:    map[string]*Job{},
+		cancels: map[string]context.CancelFunc{},
+	}
+	for _, job := range stored {
+		m.restore(job)
+	}
+	for i := 0; i < opts.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+	if m.retention > 0 {
+		m.wg.Add(1)
+		go m.sweeper()
+	}
+	return m, nil
+}
+
+func (m *Manager) jobDir(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+// restore adds a job loaded from the store, re-queueing it if the previous
+// process stopped before it finished.
+func (m *Manager) restore(job *Job) {
+	m.jobs[job.ID] = job
+	if job.State.Done() {
+		return
+	}
+
+	now := time.Now()
+	if job.Attempts >= m.maxAttempts {
+		job.FinishedAt = &now
+		job.Error = fmt.Sprintf("interrupted %d times, giving up", job.Attempts)
+		job.setState(StateFailed, now, job.Error)
+		m.notify(job)
+	} else {
+		if job.State == StateRunning {
+			job.setState(StateQueued, now, "interrupted by a restart")
+		}
+		m.queue <- job.ID
+	}
+	m.save(job)
+}
+
+// Submit stores the request read by handler in a new job directory and
+// queues it. An uploaded video is moved into the job directory. baseURL is
+// where the job API is served, used to link the result in callbacks.
+func (m *Manager) Submit(handler *handlers.EndPointHandler, baseURL string) (Job, error) {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+	if closed {
+		return Job{}, ErrClosed
+	}
+
+	id := uuid.New().String()
+	dir := m.jobDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Job{}, fmt.Errorf("cannot create job directory: %v", err)
+	}
+	spec, err := writeSpec(dir, handler)
+	if err != nil {
+		os.RemoveAll(dir)
+		return Job{}, err
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:          id,
+		CreatedAt:   now,
+		VideoName:   handler.VideoName,
+		BaseURL:     baseURL,
+		CallbackURL: handler.CallbackURL,
+	}
+	job.setState(StateQueued, now, "")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		os.RemoveAll(dir)
+		return Job{}, ErrClosed
+	}
+	if err := m.store.put(job, spec); err != nil {
+		os.RemoveAll(dir)
+		return Job{}, err
+	}
+	select {
+	case m.queue <- id:
+	default:
+		m.store.delete(id)
+		os.RemoveAll(dir)
+		return Job{}, ErrQueueFull
+	}
+	m.jobs[id] = job
+	return copyJob(job), nil
+}
+
+// writeSpec stores the video in dir and returns the rest of the request
+// encoded for the store.
+func writeSpec(dir string, handler *handlers.EndPointHandler) ([]byte, error) {
+	input := filepath.Join(dir, inputFile)
+	switch {
+	case handler.VideoPath != "":
+		if err := moveFile(handler.VideoPath, input); err != nil {
+			return nil, fmt.Errorf("cannot store video: %v", err)
+		}
+	case handler.InputVideo != nil:
+		if err := os.WriteFile(input, handler.InputVideo, 0644); err != nil {
+			return nil, fmt.Errorf("cannot store video: %v", err)
+		}
+	}
+
+	spec := *handler
+	spec.InputVideo = nil
+	data, err := json.Marshal(&spec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode job: %v", err)
+	}
+	return data, nil
+}
+
+// readSpec decodes a request encoded by writeSpec for the job in dir.
+func readSpec(dir string, data []byte) (*handlers.EndPointHandler, error) {
+	handler := &handlers.EndPointHandler{}
+	if err := json.Unmarshal(data, handler); err != nil {
+		return nil, fmt.Errorf("cannot decode job: %v", err)
+	}
+	if input := filepath.Join(dir, inputFile); fileExists(input) {
+		handler.VideoPath = input
+	}
+	return handler, nil
+}
+
+// Get returns a copy of the job with the given id.
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return copyJob(job), nil
+}
+
+// Delete cancels a queued or running job. A finished job is removed along
+// with its files.
+func (m *Manager) Delete(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if job.State.Done() {
+		if err := m.remove(id); err != nil {
+			return Job{}, err
+		}
+		return copyJob(job), nil
+	}
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.setState(StateCancelled, now, "")
+	if cancel, ok := m.cancels[id]; ok {
+		cancel()
+	}
+	m.save(job)
+	m.notify(job)
+	return copyJob(job), nil
+}
+
+// remove deletes the finished job id from the store, the jobs and the disk.
+// m.mu must be held.
+func (m *Manager) remove(id string) error {
+	if err := m.store.delete(id); err != nil {
+		return err
+	}
+	delete(m.jobs, id)
+	if err := os.RemoveAll(m.jobDir(id)); err != nil {
+		log.Printf("cannot remove job %s: %v\n", id, err)
+	}
+	return nil
+}
+
+// sweeper removes expired jobs until the manager shuts down.
+func (m *Manager) sweeper() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(max(min(m.retention/2, maxSweepInterval), time.Second))
+	defer ticker.Stop()
+	for {
+		m.sweep(time.Now())
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep removes the jobs that finished more than the retention before now,
+// and job directories as old that no job owns, such as those of a process
+// that stopped while a job was submitted.
+func (m *Manager) sweep(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, job := range m.jobs {
+		if job.State.Done() && job.FinishedAt != nil && now.Sub(*job.FinishedAt) > m.retention {
+			if err := m.remove(id); err != nil {
+				log.Printf("cannot remove expired job %s: %v\n", id, err)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		log.Printf("cannot list job directory: %v\n", err)
+		return
+	}
+	for _, entry := range entries {
+		if _, ok := m.jobs[entry.Name()]; ok || !entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && now.Sub(info.ModTime()) > m.retention {
+			if err := os.RemoveAll(m.jobDir(entry.Name())); err != nil {
+				log.Printf("cannot remove job directory %s: %v\n", entry.Name(), err)
+			}
+		}
+	}
+}
+
+// Shutdown stops taking jobs and waits for the running ones to finish. When
+// ctx ends first the running renders are cancelled, and queued again the
+// next time a manager opens the store.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.quit)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		m.notified.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.stop()
+		<-done
+	}
+	m.stop()
+	if err := m.store.close(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (m *Manager) worker() {
+	defer m.wg.Done()
+	for {
+		// prefer quitting over taking another job
+		select {
+		case <-m.quit:
+			return
+		default:
+		}
+		select {
+		case <-m.quit:
+			return
+		case id := <-m.queue:
+			m.run(id)
+		}
+	}
+}
+
+func (m *Manager) run(id string) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok || job.State != StateQueued {
+		// cancelled or deleted while queued
+		m.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.StartedAt = &now
+	job.Attempts++
+	job.setState(StateRunning, now, "")
+	m.cancels[id] = cancel
+	m.save(job)
+	m.mu.Unlock()
+
+	output, contentType, err := m.render(ctx, id)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cancels, id)
+	if job.State == StateCancelled {
+		return
+	}
+	finished := time.Now()
+	switch {
+	case err == nil:
+		job.FinishedAt = &finished
+		job.Output = output
+		job.ContentType = contentType
+		if job.BaseURL != "" {
+			job.ResultURL = job.BaseURL + "/" + id + "/result"
+		}
+		job.setState(StateSucceeded, finished, "")
+	case m.ctx.Err() != nil:
+		// left for the next manager to run again
+		job.Progress = nil
+		job.setState(StateQueued, finished, "interrupted by shutdown")
+		m.save(job)
+		return
+	default:
+		job.FinishedAt = &finished
+		job.Error = err.Error()
+		job.setState(StateFailed, finished, job.Error)
+	}
+	m.save(job)
+	m.notify(job)
+}
+
+// save writes job to the store. A failure only loses the state change if
+// the process stops, so it is logged. It is called with m.mu held.
+func (m *Manager) save(job *Job) {
+	if err := m.store.put(job, nil); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// notify delivers the callback of a finished job in the background. It is
+// called with m.mu held.
+func (m *Manager) notify(job *Job) {
+	if job.CallbackURL == "" || m.ctx.Err() != nil {
+		return
+	}
+	note := Notification{
+		JobID:    job.ID,
+		Status:   job.State,
+		Output:   job.ResultURL,
+		Duration: job.Duration().Seconds(),
+		Error:    job.Error,
+	}
+	m.notified.Add(1)
+	go func(url string) {
+		defer m.notified.Done()
+		if err := m.notifier.Notify(m.ctx, url, note); err != nil {
+			log.Printf("callback of job %s failed: %v\n", note.JobID, err)
+		}
+	}(job.CallbackURL)
+}
+
+// render reads the stored request of job id and renders it into the job
+// directory.
+func (m *Manager) render(ctx context.Context, id string) (output, contentType string, err error) {
+	dir := m.jobDir(id)
+	spec, err := m.store.spec(id)
+	if err != nil {
+		return "", "", err
+	}
+	handler, err := readSpec(dir, spec)
+	if err != nil {
+		return "", "", err
+	}
+	defer handler.Close()
+
+	vid, err := handler.Read(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	vid.Progress = func(p renderer.Progress) { m.setProgress(id, p) }
+
+	output = filepath.Join(dir, "output"+vid.FileExtension())
+	f, err := os.Create(output)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot create output: %v", err)
+	}
+	vid.Output = f
+	err = vid.RenderWithSubtitles(ctx)
+	m.setWarnings(id, vid.Warnings)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+		return "", "", err
+	}
+	return output, vid.ContentType(), nil
+}
+
+func (m *Manager) setProgress(id string, p renderer.Progress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		job.Progress = &p
+	}
+}
+
+func (m *Manager) setWarnings(id string, warnings []renderer.LineBreakWarning) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		job.Warnings = warnings
+	}
+}
+
+func copyJob(job *Job) Job {
+	c := *job
+	c.History = append([]Transition(nil), job.History...)
+	if job.Progress != nil {
+		p := *job.Progress
+		c.Progress = &p
+	}
+	return c
+}
+
+// moveFile renames src to dst, copying when they are on different devices.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,137 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket  = []byte("jobs")
+	specsBucket = []byte("specs")
+)
+
+// record is a job as stored, with the fields the API doesn't show.
+type record struct {
+	Job
+	VideoName   string `json:"videoName,omitempty"`
+	Output      string `json:"output,omitempty"`
+	BaseURL     string `json:"baseUrl,omitempty"`
+	CallbackURL string `json:"callbackUrl,omitempty"`
+}
+
+// store keeps jobs and their requests in a bbolt file so they survive a
+// restart.
+type store struct {
+	db *bolt.DB
+}
+
+func openStore(path string) (*store, error) {
+	// fail rather than wait when another process has the file open
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open job store %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, specsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot initialise job store: %v", err)
+	}
+	return &store{db}, nil
+}
+
+// put saves job, and spec when it isn't nil.
+func (s *store) put(job *Job, spec []byte) error {
+	rec := record{
+		Job:         *job,
+		VideoName:   job.VideoName,
+		Output:      job.Output,
+		BaseURL:     job.BaseURL,
+		CallbackURL: job.CallbackURL,
+	}
+	// progress changes too often to be worth saving
+	rec.Progress = nil
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("cannot encode job %s: %v", job.ID, err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if spec != nil {
+			if err := tx.Bucket(specsBucket).Put([]byte(job.ID), spec); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot save job %s: %v", job.ID, err)
+	}
+	return nil
+}
+
+// spec returns the request of job id.
+func (s *store) spec(id string) ([]byte, error) {
+	var spec []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// the value is only valid inside the transaction
+		spec = append([]byte(nil), tx.Bucket(specsBucket).Get([]byte(id))...)
+		return nil
+	})
+	if err != nil || len(spec) == 0 {
+		return nil, fmt.Errorf("cannot read job %s: not in the store", id)
+	}
+	return spec, nil
+}
+
+func (s *store) delete(id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(specsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(jobsBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("cannot delete job %s: %v", id, err)
+	}
+	return nil
+}
+
+// load returns every stored job, oldest first.
+func (s *store) load() ([]*Job, error) {
+	var jobs []*Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("cannot decode job %s: %v", k, err)
+			}
+			job := rec.Job
+			job.VideoName = rec.VideoName
+			job.Output = rec.Output
+			job.BaseURL = rec.BaseURL
+			job.CallbackURL = rec.CallbackURL
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load jobs: %v", err)
+	}
+	// keys are random ids, so order by submission
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs, nil
+}
+
+func (s *store) close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,267 @@
+package renderer
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// container is an output format ffmpeg can write to a pipe.
+type container struct {
+	format      string
+	contentType string
+	extension   string
+	args        []string
+	audioCodec  string
+	copyAudio   map[string]bool // input audio codecs stored without re-encoding
+}
+
+var containers = map[string]container{
+	// a fragmented mp4 starts with an empty moov atom, which gives players
+	// the same early start as faststart without seeking back in the output
+	"mp4": {"mp4", "video/mp4", ".mp4", []string{"-movflags", "+frag_keyframe+empty_moov+default_base_moof"}, "aac",
+		setOf("aac", "mp3", "ac3", "eac3", "alac")},
+	"mkv": {"matroska", "video/x-matroska", ".mkv", nil, "aac",
+		setOf("aac", "mp3", "ac3", "eac3", "alac", "opus", "vorbis", "flac", "dts", "truehd",
+			"pcm_s16le", "pcm_s24le", "pcm_s32le", "pcm_f32le", "pcm_u8")},
+	"webm": {"webm", "video/webm", ".webm", nil, "libopus", setOf("opus", "vorbis")},
+}
+
+// canCopy reports whether audio of the given codecs can be stored in c as
+// is. Unknown codecs are re-encoded.
+func (c container) canCopy(codecs []string) bool {
+	for _, codec := range codecs {
+		if !c.copyAudio[codec] {
+			return false
+		}
+	}
+	return len(codecs) > 0
+}
+
+func setOf(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// encoder is an ffmpeg encoder library for a codec.
+type encoder struct {
+	name       string
+	presetFlag string
+	preset     string // default speed preset
+	crf        int    // default quality
+	maxCRF     int
+	args       []string
+	presets    []string // values presetFlag accepts
+}
+
+// x26xPresets are the speed presets of libx264 and libx265.
+var x26xPresets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow", "placebo"}
+
+// numbers returns the numbers from lo to hi as strings.
+func numbers(lo, hi int) []string {
+	var s []string
+	for i := lo; i <= hi; i++ {
+		s = append(s, fmt.Sprint(i))
+	}
+	return s
+}
+
+// codecs lists the encoders of each codec in order of preference.
+var codecs = map[string][]encoder{
+	"x264": {{"libx264", "-preset", "ultrafast", 23, 51, nil, x26xPresets}},
+	"x265": {{"libx265", "-preset", "ultrafast", 28, 51, []string{"-tag:v", "hvc1"}, x26xPresets}},
+	"vp9":  {{"libvpx-vp9", "-deadline", "realtime", 32, 63, []string{"-row-mt", "1"}, []string{"realtime", "good", "best"}}},
+	"av1": {
+		{"libsvtav1", "-preset", "10", 35, 63, nil, numbers(0, 13)},
+		{"libaom-av1", "-cpu-used", "8", 35, 63, []string{"-row-mt", "1"}, numbers(0, 8)},
+	},
+}
+
+// aliases maps other common codec and container names to the ones used here.
+var aliases = map[string]string{
+	"h264": "x264", "avc": "x264", "libx264": "x264",
+	"h265": "x265", "hevc": "x265", "libx265": "x265",
+	"libvpx-vp9": "vp9",
+	"libsvtav1":  "av1", "libaom-av1": "av1",
+	"matroska": "mkv",
+}
+
+// webmCodecs are the only video codecs WebM allows.
+var webmCodecs = map[string]bool{"vp9": true, "av1": true}
+
+// Profiles are encoding presets for common platforms.
+var Profiles = map[string]types.Encoding{
+	"tiktok-1080x1920":  {Codec: "x264", CRF: 20, Preset: "veryfast", PixelFormat: "yuv420p", Container: "mp4", Width: 1080, Height: 1920},
+	"reels-1080x1920":   {Codec: "x264", CRF: 20, Preset: "veryfast", PixelFormat: "yuv420p", Container: "mp4", Width: 1080, Height: 1920},
+	"shorts-1080x1920":  {Codec: "x264", CRF: 20, Preset: "veryfast", PixelFormat: "yuv420p", Container: "mp4", Width: 1080, Height: 1920},
+	"youtube-1920x1080": {Codec: "x264", CRF: 18, Preset: "veryfast", PixelFormat: "yuv420p", Container: "mp4", Width: 1920, Height: 1080},
+	"square-1080x1080":  {Codec: "x264", CRF: 20, Preset: "veryfast", PixelFormat: "yuv420p", Container: "mp4", Width: 1080, Height: 1080},
+	"web-vp9":           {Codec: "vp9", CRF: 32, PixelFormat: "yuv420p", Container: "webm"},
+	"web-av1":           {Codec: "av1", CRF: 35, PixelFormat: "yuv420p", Container: "webm"},
+}
+
+var bitrateRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmM]?$`)
+
+var (
+	encodersOnce sync.Once
+	encoderList  string
+)
+
+// hasEncoder reports whether the local ffmpeg was built with the encoder. If
+// ffmpeg cannot be queried every encoder is assumed to exist.
+func hasEncoder(name string) bool {
+	encodersOnce.Do(func() {
+		out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+		if err == nil {
+			encoderList = string(out)
+		}
+	})
+	return encoderList == "" || strings.Contains(encoderList, " "+name+" ")
+}
+
+// pickEncoder returns the first available encoder of codec.
+func pickEncoder(codec string) encoder {
+	candidates := codecs[codec]
+	for _, e := range candidates {
+		if hasEncoder(e.name) {
+			return e
+		}
+	}
+	return candidates[0]
+}
+
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := aliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// ResolveEncoding fills the fields of enc left empty from its profile and
+// then from the defaults of the codec, and checks the result.
+func ResolveEncoding(enc types.Encoding) (types.Encoding, error) {
+	if enc.Profile != "" {
+		profile, ok := Profiles[strings.ToLower(enc.Profile)]
+		if !ok {
+			return enc, fmt.Errorf("unknown encoding profile %q", enc.Profile)
+		}
+		// the preset of a profile only fits the codec of the profile
+		if enc.Codec == "" || normalizeName(enc.Codec) == profile.Codec {
+			enc.Preset = firstNonEmpty(enc.Preset, profile.Preset)
+		}
+		enc.Codec = firstNonEmpty(enc.Codec, profile.Codec)
+		enc.Bitrate = firstNonEmpty(enc.Bitrate, profile.Bitrate)
+		enc.PixelFormat = firstNonEmpty(enc.PixelFormat, profile.PixelFormat)
+		enc.Container = firstNonEmpty(enc.Container, profile.Container)
+		if enc.CRF == 0 && enc.Bitrate == "" {
+			enc.CRF = profile.CRF
+		}
+		if enc.Width == 0 && enc.Height == 0 {
+			enc.Width, enc.Height = profile.Width, profile.Height
+		}
+	}
+
+	enc.Codec = normalizeName(firstNonEmpty(enc.Codec, "x264"))
+	enc.Container = normalizeName(firstNonEmpty(enc.Container, "mp4"))
+	enc.PixelFormat = firstNonEmpty(enc.PixelFormat, "yuv420p")
+
+	if _, ok := codecs[enc.Codec]; !ok {
+		return enc, fmt.Errorf("unknown codec %q, expected x264, x265, vp9 or av1", enc.Codec)
+	}
+	if _, ok := containers[enc.Container]; !ok {
+		return enc, fmt.Errorf("unknown container %q, expected mp4, mkv or webm", enc.Container)
+	}
+	if enc.Container == "webm" && !webmCodecs[enc.Codec] {
+		return enc, fmt.Errorf("codec %s cannot be stored in webm, use vp9 or av1", enc.Codec)
+	}
+
+	e := pickEncoder(enc.Codec)
+	enc.Preset = firstNonEmpty(enc.Preset, e.preset)
+	if !slices.Contains(e.presets, enc.Preset) {
+		return enc, fmt.Errorf("invalid preset %q for %s, expected one of %s", enc.Preset, e.name, strings.Join(e.presets, ", "))
+	}
+	if enc.Bitrate != "" {
+		if !bitrateRe.MatchString(enc.Bitrate) {
+			return enc, fmt.Errorf("invalid bitrate %q", enc.Bitrate)
+		}
+		enc.CRF = 0
+	} else if enc.CRF == 0 {
+		enc.CRF = e.crf
+	}
+	if enc.CRF < 0 || enc.CRF > e.maxCRF {
+		return enc, fmt.Errorf("crf %d out of range for %s, expected 0 to %d", enc.CRF, enc.Codec, e.maxCRF)
+	}
+	if enc.Width < 0 || enc.Height < 0 || enc.Width%2 != 0 || enc.Height%2 != 0 {
+		return enc, fmt.Errorf("invalid output size %dx%d, width and height must be even", enc.Width, enc.Height)
+	}
+	if (enc.Width == 0) != (enc.Height == 0) {
+		return enc, fmt.Errorf("output size needs both width and height")
+	}
+	return enc, nil
+}
+
+// ContentType returns the MIME type of a container, defaulting to mp4.
+func ContentType(name string) string {
+	return lookupContainer(name).contentType
+}
+
+// FileExtension returns the file extension of a container, defaulting to
+// mp4.
+func FileExtension(name string) string {
+	return lookupContainer(name).extension
+}
+
+func lookupContainer(name string) container {
+	if c, ok := containers[normalizeName(name)]; ok {
+		return c
+	}
+	return containers["mp4"]
+}
+
+// encodingArgs returns the video encoder and container arguments of a
+// resolved encoding.
+func encodingArgs(enc types.Encoding) []string {
+	e := pickEncoder(enc.Codec)
+	args := []string{"-c:v", e.name, e.presetFlag, enc.Preset}
+	if enc.Bitrate != "" {
+		args = append(args, "-b:v", enc.Bitrate)
+	} else {
+		args = append(args, "-crf", fmt.Sprintf("%d", enc.CRF))
+		if enc.Codec == "vp9" || e.name == "libaom-av1" {
+			// constant quality mode needs the bitrate cap removed
+			args = append(args, "-b:v", "0")
+		}
+	}
+	args = append(args, e.args...)
+	args = append(args, "-pix_fmt", enc.PixelFormat)
+
+	c := containers[enc.Container]
+	args = append(args, c.args...)
+	return append(args, "-f", c.format)
+}
+
+// scaleFilter fits the video into the output size of enc, padding the rest.
+func scaleFilter(enc types.Encoding) string {
+	if enc.Width == 0 || enc.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf(",scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1", enc.Width, enc.Height)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
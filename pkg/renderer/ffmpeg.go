@@ -1,130 +1,173 @@
 package renderer
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"os"
+	"io"
+	"net/url"
 	"os/exec"
 	"strconv"
-	"strings"
 
+	"github.com/elweday/go-subtitles/pkg/types"
 	"github.com/elweday/go-subtitles/pkg/utils"
 )
 
-func FFmpegCombineImagesToVideo(frames [][]byte, inputVideoData []byte, aspectRatio string, frameRate int, offset float64) ([]byte, error) {
-	inputFile, err := utils.WriteTemp(inputVideoData)
-	if err != nil {
-		return nil, err
+// AudioOptions controls the audio track of the rendered video.
+type AudioOptions struct {
+	HasAudio    bool     // the input video has an audio stream
+	Codecs      []string // codecs of the audio streams of the input video
+	Replacement string   // file or URL of audio that replaces the input audio
+	Music       string   // file or URL of music looped under the replacement or input audio
+	MusicGain   float64  // gain applied to the music, in dB
+}
+
+// urlProtocols are the protocols ffmpeg may open for an input read from a
+// URL. Without them a playlist behind the URL could open local files or
+// reach other protocols.
+const urlProtocols = "https,http,tls,tcp"
+
+// isURL reports whether path names a URL rather than a file.
+func isURL(path string) bool {
+	u, err := url.Parse(path)
+	// a one letter scheme is a Windows drive
+	return err == nil && len(u.Scheme) > 1
+}
+
+// inputArgs returns the ffmpeg arguments that read the input at path,
+// limiting the protocols of URLs.
+func inputArgs(path string) []string {
+	if isURL(path) {
+		return []string{"-protocol_whitelist", urlProtocols, "-i", path}
+	}
+	return []string{"-i", path}
+}
+
+// audioArgs returns the input, filter and mapping arguments for the audio of
+// the output. Input 1 is the video, followed by the replacement audio and the
+// music, when they are given. The music plays under the replacement audio,
+// or else under the audio of the video.
+func audioArgs(audio AudioOptions, c container) (inputs []string, filter string, mapping []string) {
+	switch {
+	case audio.Music != "":
+		voice := utils.Iff(audio.HasAudio, "[1:a]", "")
+		music := 2
+		if audio.Replacement != "" {
+			inputs = inputArgs(audio.Replacement)
+			filter = ";[2:a]apad[voice]"
+			voice, music = "[voice]", 3
+		}
+		inputs = append(inputs, "-stream_loop", "-1")
+		inputs = append(inputs, inputArgs(audio.Music)...)
+		filter += fmt.Sprintf(";[%d:a]volume=%fdB[music]", music, audio.MusicGain)
+		if voice != "" {
+			filter += ";" + voice + "[music]amix=inputs=2:duration=first:dropout_transition=0:normalize=0[aout]"
+		} else {
+			filter += ";[music]anull[aout]"
+		}
+	case audio.Replacement != "":
+		inputs = inputArgs(audio.Replacement)
+		filter = ";[2:a]apad[aout]"
+	case audio.HasAudio:
+		// Copy the audio stream from the input video without re-encoding
+		// when the container accepts its codec
+		return nil, "", []string{"-map", "1:a", "-c:a", utils.Iff(c.canCopy(audio.Codecs), "copy", c.audioCodec)}
+	default:
+		return nil, "", nil
 	}
-	defer os.Remove(inputFile.Name())
+	// the extra audio is padded or looped, so stop with the video
+	return inputs, filter, []string{"-map", "[aout]", "-c:a", c.audioCodec, "-shortest"}
+}
 
-	cmd := exec.Command("ffmpeg",
+// FFmpegCombineImagesToVideo overlays the PNG frames read from frames on the
+// video at inputPath, which may be a file or a URL, and writes the encoded
+// result to out.
+func FFmpegCombineImagesToVideo(ctx context.Context, frames <-chan []byte, inputPath string, out io.Writer, aspectRatio string, frameRate float64, offset float64, audio AudioOptions, enc types.Encoding, onFrame func(frames int)) error {
+	audioInputs, audioFilter, audioMapping := audioArgs(audio, containers[enc.Container])
+
+	args := []string{
 		"-y",
+		"-hide_banner",
+		"-nostats",
+		"-progress", "pipe:2",
 		"-f", "image2pipe",
-		"-framerate", fmt.Sprintf("%d", frameRate),
+		"-framerate", strconv.FormatFloat(frameRate, 'f', -1, 64),
 		"-video_size", aspectRatio,
 		"-i", "pipe:0",
-		"-f", "mp4",
-		"-i", inputFile.Name(),
-		"-filter_complex", fmt.Sprintf("[1:v][0:v]overlay=0:%f[out]", offset), // Overlay images over background video
+	}
+	args = append(args, inputArgs(inputPath)...)
+	args = append(args, audioInputs...)
+	args = append(args,
+		"-filter_complex", fmt.Sprintf("[1:v][0:v]overlay=0:%f%s[out]", offset, scaleFilter(enc))+audioFilter, // Overlay images over background video
 		"-map", "[out]",
-		"-map", "1:a", // Map the audio stream from the input video
-		"-c:a", "copy", // Copy the audio stream without re-encoding
-		"-c:v", "libx264",
-		"-preset", "ultrafast",
-		"-pix_fmt", "yuv420p",
-		"-f", "matroska",
-		"-",
 	)
+	args = append(args, audioMapping...)
+	args = append(args, encodingArgs(enc)...)
+	args = append(args, "-")
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
 	stdinImages, err := cmd.StdinPipe()
-	out := []byte{}
-	outBuf := bytes.NewBuffer(out)
-	cmd.Stdout = outBuf
+	cmd.Stdout = out
+	stderr := newRingBuffer(stderrBufferSize)
+	cmd.Stderr = &progressWriter{log: stderr, onFrame: onFrame}
 	if err != nil {
-		return nil, fmt.Errorf("error getting stdin pipe for images: %v", err)
+		return fmt.Errorf("error getting stdin pipe for images: %v", err)
 	}
 
 	// Start ffmpeg process
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("error starting ffmpeg: %v", err)
+		return fmt.Errorf("error starting ffmpeg: %v", err)
 	}
 
 	// Write images to stdin
-	for _, imgData := range frames {
+	for imgData := range frames {
 		_, err := stdinImages.Write(imgData)
 		if err != nil {
-			return nil, fmt.Errorf("error writing image data to stdin: %v", err)
+			// ffmpeg exited or was killed, reap it before returning
+			stdinImages.Close()
+			waitErr := cmd.Wait()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if waitErr != nil {
+				return newFFmpegError(cmd, waitErr, stderr)
+			}
+			return fmt.Errorf("error writing image data to stdin: %v", err)
 		}
 	}
 
 	// Close stdin for images to signal end of input
 	if err := stdinImages.Close(); err != nil {
-		return nil, fmt.Errorf("error closing stdin for images: %v", err)
+		return fmt.Errorf("error closing stdin for images: %v", err)
 	}
 
 	// Wait for ffmpeg to finish
 	if err := cmd.Wait(); err != nil {
-		return nil, fmt.Errorf("error waiting for ffmpeg: %v", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return newFFmpegError(cmd, err, stderr)
 	}
 
-	return outBuf.Bytes(), nil
+	return nil
 }
 
-func FFmpegExtractAudio(videoBytes []byte) ([]byte, error) {
-	// Create pipes for input and output
-	reader := bytes.NewReader(videoBytes)
-	writer := bytes.NewBuffer(nil)
-
-	// Build ffmpeg command with pipes
-	cmd := exec.Command("ffmpeg", "-i", "-", "-vn", "-acodec", "copy", "-")
-	cmd.Stdin = reader
-	cmd.Stdout = writer    // Pipe output to a buffer
-	cmd.Stderr = os.Stderr // Capture ffmpeg errors
+// FFmpegExtractAudio copies the audio stream of the video at inputPath to
+// out as Matroska audio.
+func FFmpegExtractAudio(ctx context.Context, inputPath string, out io.Writer) error {
+	args := append([]string{"-hide_banner"}, inputArgs(inputPath)...)
+	args = append(args, "-vn", "-acodec", "copy", "-f", "matroska", "-")
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = out
+	stderr := newRingBuffer(stderrBufferSize)
+	cmd.Stderr = stderr // Capture ffmpeg errors
 
 	// Run ffmpeg command
 	err := cmd.Run()
 	if err != nil {
-		return nil, err
-	}
-
-	// Get extracted audio from the buffer
-	audioBytes := writer.Bytes()
-
-	return audioBytes, nil
-}
-
-// GetVideoDimensions returns the width and height of a video file as integers
-func FFmpegGetVideoDimensions(videoData []byte) (int, int, error) {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-show_entries", "stream=width,height",
-		"-of", "csv=s=x:p=0",
-		"-",
-	)
-
-	cmd.Stdin = bytes.NewReader(videoData)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, 0, fmt.Errorf("error running ffprobe: %v", err)
-	}
-
-	dimensions := strings.Split(strings.TrimSpace(string(output)), "x")
-	if len(dimensions) != 2 {
-		return 0, 0, fmt.Errorf("unexpected dimensions format: %s", string(output))
-	}
-
-	width, err := strconv.Atoi(dimensions[0])
-	if err != nil {
-		return 0, 0, fmt.Errorf("error parsing width: %v", err)
-	}
-
-	height, err := strconv.Atoi(dimensions[1])
-	if err != nil {
-		return 0, 0, fmt.Errorf("error parsing height: %v", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return newFFmpegError(cmd, err, stderr)
 	}
-
-	return width, height, nil
+	return nil
 }
@@ -0,0 +1,155 @@
+package renderer
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	stderrBufferSize = 64 << 10 // bytes of ffmpeg output kept
+	logTailLines     = 20       // lines of the log shown in errors
+)
+
+// ringBuffer is an io.Writer that keeps only the last size bytes written.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(p)
+	if n >= r.size {
+		r.buf = append(r.buf[:0], p[n-r.size:]...)
+		return n, nil
+	}
+	if drop := len(r.buf) + n - r.size; drop > 0 {
+		r.buf = append(r.buf[:0], r.buf[drop:]...)
+	}
+	r.buf = append(r.buf, p...)
+	return n, nil
+}
+
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.buf)
+}
+
+// FFmpegError is returned when ffmpeg or ffprobe exits with an error.
+type FFmpegError struct {
+	Command  string // command line that was run
+	ExitCode int    // -1 if the process did not exit normally
+	Reason   string // cause recognised in the log, empty if unknown
+	Log      string // last lines of stderr
+	Err      error
+}
+
+func (e *FFmpegError) Error() string {
+	msg := fmt.Sprintf("%s exited with code %d", strings.SplitN(e.Command, " ", 2)[0], e.ExitCode)
+	if e.Reason != "" {
+		return msg + ": " + e.Reason
+	}
+	if last := lastLine(e.Log); last != "" {
+		return msg + ": " + last
+	}
+	return msg
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// ffmpegFailures maps common ffmpeg log messages to a short reason. The
+// first submatch, if any, is passed to the format.
+var ffmpegFailures = []struct {
+	re     *regexp.Regexp
+	format string
+}{
+	{regexp.MustCompile(`Unknown encoder '([^']+)'`), "unknown codec %s, ffmpeg was built without it"},
+	{regexp.MustCompile(`Unknown decoder '([^']+)'`), "unknown decoder %s"},
+	{regexp.MustCompile(`Could not find tag for codec (\S+) in stream`), "codec %s is not supported by the container"},
+	{regexp.MustCompile(`Stream (?:map|specifier) '([^']*)'.*matches no streams`), "missing stream %s in the input"},
+	{regexp.MustCompile(`does not contain any stream`), "the input has no streams"},
+	{regexp.MustCompile(`(?i)(width|height) not divisible by 2`), "invalid dimensions, %s must be even"},
+	{regexp.MustCompile(`Picture size (\d+x\d+) is invalid`), "invalid dimensions %s"},
+	{regexp.MustCompile(`Invalid (?:frame )?dimensions`), "invalid dimensions"},
+	{regexp.MustCompile(`moov atom not found|Invalid data found when processing input`), "the input is not a valid video"},
+	{regexp.MustCompile(`No such file or directory`), "input file not found"},
+}
+
+// parseFFmpegLog returns the reason of the first known failure in log.
+func parseFFmpegLog(log string) string {
+	for _, f := range ffmpegFailures {
+		m := f.re.FindStringSubmatch(log)
+		if m == nil {
+			continue
+		}
+		if len(m) > 1 {
+			return fmt.Sprintf(f.format, m[1])
+		}
+		return f.format
+	}
+	return ""
+}
+
+// newFFmpegError wraps the error of a finished command with its stderr.
+func newFFmpegError(cmd *exec.Cmd, err error, stderr *ringBuffer) *FFmpegError {
+	log := stderr.String()
+	e := &FFmpegError{
+		Command:  commandLine(cmd.Args),
+		ExitCode: -1,
+		Reason:   parseFFmpegLog(log),
+		Log:      tail(log, logTailLines),
+		Err:      err,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		e.ExitCode = exitErr.ExitCode()
+	}
+	return e
+}
+
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t'\";[]") {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func tail(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	return s[strings.LastIndex(s, "\n")+1:]
+}
+
+// CheckTools reports whether ffmpeg and ffprobe can be found on PATH.
+func CheckTools() error {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%s not found: %v", tool, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package renderer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+	"github.com/elweday/go-subtitles/pkg/utils"
+
+	"golang.org/x/image/font"
+)
+
+// LineBreakWarning describes a line-breaking rule the transcript could not
+// satisfy, starting at word Word.
+type LineBreakWarning struct {
+	Word    int    `json:"word"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (w LineBreakWarning) String() string {
+	return fmt.Sprintf("word %d: %s: %s", w.Word, w.Rule, w.Message)
+}
+
+// lineBreaker splits a transcript into cues (pages of at most MaxLines lines)
+// and then picks the line breaks inside each cue.
+type lineBreaker struct {
+	words  []types.Word
+	opts   types.SubtitlesOptions
+	widths []float64 // word width plus the following space
+	chars  []int
+	avail  float64 // room for words on a line
+
+	lineStart map[int]bool // a line has to start at this word
+	pageStart map[int]bool // a cue has to start at this word
+	noBreak   map[string]bool
+
+	warnings []LineBreakWarning
+}
+
+// BreakLines lays words out into lines the way SplitIntoLines does, applying
+// the line-breaking rules in opts, and returns the rules it could not keep.
+// Lines are grouped into pages of opts.MaxLines, padded with empty lines
+// where a page ends early. Words are measured as DrawFrame2 lays them out:
+// in boldFace when their style is bold, in face otherwise, at their scale.
+func BreakLines(words []types.Word, face, boldFace font.Face, opts types.SubtitlesOptions) ([][]types.Word, map[int]int, map[int]float64, []LineBreakWarning) {
+	drawer := &font.Drawer{Face: face}
+	boldDrawer := &font.Drawer{Face: boldFace}
+	spaceWidth := float64(drawer.MeasureString(strings.Repeat(" ", opts.WordSpacing)) >> 6)
+
+	b := &lineBreaker{
+		words:     words,
+		opts:      opts,
+		avail:     float64(opts.Width) - 3*float64(opts.Padding),
+		lineStart: map[int]bool{},
+		pageStart: map[int]bool{},
+		noBreak:   map[string]bool{},
+	}
+	for _, w := range opts.NoBreakAfter {
+		b.noBreak[utils.NormalizeWord(w)] = true
+	}
+	for i, word := range words {
+		wordWidth := float64(utils.Iff(word.Style != nil && word.Style.Bold, boldDrawer, drawer).MeasureString(word.Value) >> 6)
+		if word.Style != nil && word.Style.Scale > 0 {
+			wordWidth *= word.Style.Scale
+		}
+		b.widths = append(b.widths, wordWidth+spaceWidth)
+		b.chars = append(b.chars, utf8.RuneCountInString(word.Value))
+
+		if i == 0 {
+			continue
+		}
+		speakerChange := word.Speaker != words[i-1].Speaker
+		phraseBreak := IsPhraseBreak(words, i-1, opts)
+		b.lineStart[i] = speakerChange || phraseBreak
+		b.pageStart[i] = (speakerChange && opts.SpeakerNewPage) || (phraseBreak && opts.PhraseBreak != "line")
+	}
+
+	lines := [][]types.Word{}
+	indexLineMap := map[int]int{}
+	lineWidthMap := map[int]float64{}
+	for _, cue := range b.cues() {
+		for _, line := range b.layoutCue(cue[0], cue[1]) {
+			lineWidthMap[len(lines)] = float64(opts.Padding) + b.width(line[0], line[1])
+			for i := line[0]; i < line[1]; i++ {
+				indexLineMap[i] = len(lines)
+			}
+			lines = append(lines, words[line[0]:line[1]])
+		}
+		for opts.MaxLines > 0 && len(lines)%opts.MaxLines != 0 {
+			lineWidthMap[len(lines)] = 0
+			lines = append(lines, []types.Word{})
+		}
+		b.checkCue(cue[0], cue[1])
+	}
+	if len(lines) == 0 {
+		lines = append(lines, []types.Word{})
+	}
+
+	return lines, indexLineMap, lineWidthMap, b.warnings
+}
+
+func (b *lineBreaker) warn(word int, rule, format string, args ...any) {
+	b.warnings = append(b.warnings, LineBreakWarning{word, rule, fmt.Sprintf(format, args...)})
+}
+
+func (b *lineBreaker) width(start, end int) float64 {
+	sum := 0.0
+	for i := start; i < end; i++ {
+		sum += b.widths[i]
+	}
+	return sum
+}
+
+// lineChars counts the characters of words[start:end] including spaces.
+func (b *lineBreaker) lineChars(start, end int) int {
+	sum := end - start - 1
+	for i := start; i < end; i++ {
+		sum += b.chars[i]
+	}
+	return sum
+}
+
+// cueDuration returns how long the cue made of words[start:end] is on screen.
+// A last word without a duration is counted up to its start.
+func (b *lineBreaker) cueDuration(start, end int) float64 {
+	stop := holdEnd(b.words, end-1, b.opts)
+	if math.IsInf(stop, 1) {
+		stop = b.words[end-1].Time
+	}
+	return stop - b.words[start].Time
+}
+
+func (b *lineBreaker) endsPhrase(i int) bool {
+	runes := []rune(b.words[i].Value)
+	if len(runes) == 0 {
+		return false
+	}
+	// shaped Arabic is stored reversed, so its punctuation comes first
+	return unicode.IsPunct(runes[len(runes)-1]) || unicode.IsPunct(runes[0])
+}
+
+func (b *lineBreaker) isNoBreak(i int) bool {
+	return b.noBreak[utils.NormalizeWord(b.words[i].Value)]
+}
+
+// fitsLine reports whether words[start:end] can share a line. A single word
+// always fits, even when it breaks the limits.
+func (b *lineBreaker) fitsLine(start, end int) bool {
+	if end-start == 1 {
+		return true
+	}
+	for i := start + 1; i < end; i++ {
+		if b.lineStart[i] {
+			return false
+		}
+	}
+	if b.opts.MaxCharsPerLine > 0 && b.lineChars(start, end) > b.opts.MaxCharsPerLine {
+		return false
+	}
+	return b.width(start, end) <= b.avail
+}
+
+// lineCost scores a line ending at word end-1; lower is better.
+func (b *lineBreaker) lineCost(start, end int, lastInCue bool) float64 {
+	cost := 0.0
+	if b.opts.BalanceLines {
+		slack := math.Max(b.avail-b.width(start, end), 0) / b.avail
+		cost += slack * slack
+	}
+	if !lastInCue {
+		if b.isNoBreak(end - 1) {
+			cost += 10
+		}
+		if b.opts.BreakAtPunctuation && !b.endsPhrase(end-1) {
+			cost += 0.5
+		}
+	}
+	return cost
+}
+
+// layoutCue picks the line breaks for words[start:end] using the fewest
+// lines possible, then the lowest cost. It returns nil when the words do not
+// fit in MaxLines lines.
+func (b *lineBreaker) layoutCue(start, end int) [][2]int {
+	n := end - start
+	maxLines := n
+	if b.opts.MaxLines > 0 {
+		maxLines = min(b.opts.MaxLines, n)
+	}
+
+	// cost[l][j] is the best cost of putting the first j words on l lines
+	inf := math.Inf(1)
+	cost := make([][]float64, maxLines+1)
+	from := make([][]int, maxLines+1)
+	for l := range cost {
+		cost[l] = make([]float64, n+1)
+		from[l] = make([]int, n+1)
+		for j := range cost[l] {
+			cost[l][j] = inf
+		}
+	}
+	cost[0][0] = 0
+
+	for l := 1; l <= maxLines; l++ {
+		for j := 1; j <= n; j++ {
+			for i := j - 1; i >= 0; i-- {
+				if !b.fitsLine(start+i, start+j) {
+					break
+				}
+				if cost[l-1][i] == inf {
+					continue
+				}
+				c := cost[l-1][i] + b.lineCost(start+i, start+j, j == n)
+				if c < cost[l][j] {
+					cost[l][j] = c
+					from[l][j] = i
+				}
+			}
+		}
+		if cost[l][n] < inf {
+			lines := make([][2]int, l)
+			for j := n; l > 0; l-- {
+				lines[l-1] = [2]int{start + from[l][j], start + j}
+				j = from[l][j]
+			}
+			return lines
+		}
+	}
+	return nil
+}
+
+// cues splits the transcript into ranges of words shown together on a page.
+// Each cue is grown while it fits on a page and within MaxCueDuration, then
+// pulled back to a better break point when one is close.
+//
+// Whether a cue fits only depends on the fewest lines it needs, which filling
+// each line greedily gives, so the lines are counted as the cue grows rather
+// than laid out again for every word.
+func (b *lineBreaker) cues() [][2]int {
+	cues := [][2]int{}
+	for start := 0; start < len(b.words); {
+		end := start + 1
+		lines, lineStart := 1, start
+		for end < len(b.words) && !b.pageStart[end] {
+			if b.opts.MaxCueDuration > 0 && b.cueDuration(start, end+1) > b.opts.MaxCueDuration {
+				break
+			}
+			if !b.fitsLine(lineStart, end+1) {
+				if b.opts.MaxLines > 0 && lines == b.opts.MaxLines {
+					break
+				}
+				lines, lineStart = lines+1, end
+			}
+			end++
+		}
+
+		if end < len(b.words) && !b.pageStart[end] {
+			end = b.betterCueEnd(start, end)
+		}
+		cues = append(cues, [2]int{start, end})
+		start = end
+	}
+	return cues
+}
+
+// betterCueEnd looks back over the second half of a full cue for a break at
+// punctuation, or at least one that is not after an article or preposition.
+func (b *lineBreaker) betterCueEnd(start, end int) int {
+	acceptable := func(e int) bool {
+		return b.opts.MinCueDuration <= 0 || b.cueDuration(start, e) >= b.opts.MinCueDuration
+	}
+	half := start + (end-start+1)/2
+	if b.opts.BreakAtPunctuation && !b.endsPhrase(end-1) {
+		for e := end - 1; e > half; e-- {
+			if b.endsPhrase(e-1) && acceptable(e) {
+				return e
+			}
+		}
+	}
+	for e := end; e > half; e-- {
+		if !b.isNoBreak(e-1) && acceptable(e) {
+			return e
+		}
+	}
+	return end
+}
+
+// checkCue records the rules the cue words[start:end] breaks. MaxCPS is only
+// checked here: cue timing comes from the transcript, so breaking a cue
+// earlier can't slow it down.
+func (b *lineBreaker) checkCue(start, end int) {
+	opts := b.opts
+	for i := start; i < end; i++ {
+		if opts.MaxCharsPerLine > 0 && b.chars[i] > opts.MaxCharsPerLine {
+			b.warn(i, "maxCharsPerLine", "%q is longer than %d characters", b.words[i].Value, opts.MaxCharsPerLine)
+		}
+		if b.widths[i] > b.avail {
+			b.warn(i, "width", "%q is wider than the frame", b.words[i].Value)
+		}
+	}
+
+	if math.IsInf(holdEnd(b.words, end-1, opts), 1) {
+		return
+	}
+	duration := b.cueDuration(start, end)
+	if opts.MinCueDuration > 0 && duration < opts.MinCueDuration {
+		b.warn(start, "minCueDuration", "cue is shown for %.2fs, less than %.2fs", duration, opts.MinCueDuration)
+	}
+	if opts.MaxCueDuration > 0 && duration > opts.MaxCueDuration {
+		b.warn(start, "maxCueDuration", "cue is shown for %.2fs, more than %.2fs", duration, opts.MaxCueDuration)
+	}
+	if cps := float64(b.lineChars(start, end)) / duration; opts.MaxCPS > 0 && duration > 0 && cps > opts.MaxCPS {
+		b.warn(start, "maxCPS", "cue needs %.1f characters per second, more than %.1f", cps, opts.MaxCPS)
+	}
+}
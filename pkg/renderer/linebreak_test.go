@@ -0,0 +1,311 @@
+package renderer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+// glyphWidth is the advance of every glyph of basicfont.Face7x13.
+const glyphWidth = 7
+
+// transcript makes a word every half second, each lasting 0.4s.
+func transcript(text string) []types.Word {
+	var words []types.Word
+	for i, w := range strings.Fields(text) {
+		words = append(words, types.Word{Value: w, Time: float64(i) * 0.5, Duration: 0.4})
+	}
+	return words
+}
+
+// lineOpts fits chars characters, spaces included, on a line of the
+// monospace face.
+func lineOpts(chars int) types.SubtitlesOptions {
+	// a line holds the width of its words and a space after each
+	return types.SubtitlesOptions{Width: (chars + 1) * glyphWidth, WordSpacing: 1}
+}
+
+// layout writes lines as "a b / c d", with "-" for the empty lines padding
+// a page.
+func layout(lines [][]types.Word) string {
+	var out []string
+	for _, line := range lines {
+		var values []string
+		for _, w := range line {
+			values = append(values, w.Value)
+		}
+		out = append(out, strings.Join(values, " "))
+		if len(line) == 0 {
+			out[len(out)-1] = "-"
+		}
+	}
+	return strings.Join(out, " / ")
+}
+
+func breakLines(words []types.Word, opts types.SubtitlesOptions) ([][]types.Word, []LineBreakWarning) {
+	lines, _, _, warnings := BreakLines(words, basicfont.Face7x13, basicfont.Face7x13, opts)
+	return lines, warnings
+}
+
+func TestBreakLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []types.Word
+		opts  func(*types.SubtitlesOptions)
+		want  string
+	}{
+		{
+			name:  "fills lines to the width",
+			words: transcript("one two three four five six seven"),
+			want:  "one two three / four five six / seven",
+		},
+		{
+			name:  "pads pages to max lines",
+			words: transcript("one two three four five six seven"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxLines = 2 },
+			want:  "one two three / four five six / seven / -",
+		},
+		{
+			name:  "max chars per line",
+			words: transcript("one two three four five six seven"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxCharsPerLine = 9 },
+			want:  "one two / three / four five / six seven",
+		},
+		{
+			name:  "a word longer than the limits keeps a line of its own",
+			words: transcript("a extraordinarily b"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxCharsPerLine = 5 },
+			want:  "a / extraordinarily / b",
+		},
+		{
+			name: "speaker change starts a line",
+			words: func() []types.Word {
+				words := transcript("one two three four")
+				words[2].Speaker, words[3].Speaker = "B", "B"
+				return words
+			}(),
+			opts: func(o *types.SubtitlesOptions) { o.MaxLines = 2 },
+			want: "one two / three four",
+		},
+		{
+			name: "speaker change starts a page when asked",
+			words: func() []types.Word {
+				words := transcript("one two three four")
+				words[2].Speaker, words[3].Speaker = "B", "B"
+				return words
+			}(),
+			opts: func(o *types.SubtitlesOptions) { o.MaxLines = 2; o.SpeakerNewPage = true },
+			want: "one two / - / three four / -",
+		},
+		{
+			name: "phrase break starts a page",
+			words: func() []types.Word {
+				words := transcript("one two three four")
+				words[2].Time, words[3].Time = 3, 3.5
+				return words
+			}(),
+			opts: func(o *types.SubtitlesOptions) { o.MaxLines = 2; o.GapThreshold = 1 },
+			want: "one two / - / three four / -",
+		},
+		{
+			name: "phrase break starts a line when asked",
+			words: func() []types.Word {
+				words := transcript("one two three four")
+				words[2].Time, words[3].Time = 3, 3.5
+				return words
+			}(),
+			opts: func(o *types.SubtitlesOptions) { o.MaxLines = 2; o.GapThreshold = 1; o.PhraseBreak = "line" },
+			want: "one two / three four",
+		},
+		{
+			name:  "lines may end on articles",
+			words: transcript("one two the big cat"),
+			want:  "one two the / big cat",
+		},
+		{
+			name:  "no break after articles",
+			words: transcript("one two the big cat"),
+			opts:  func(o *types.SubtitlesOptions) { o.NoBreakAfter = []string{"the"} },
+			want:  "one two / the big cat",
+		},
+		{
+			name:  "no break after ignores case",
+			words: transcript("one two The big cat"),
+			opts:  func(o *types.SubtitlesOptions) { o.NoBreakAfter = []string{"THE"} },
+			want:  "one two / The big cat",
+		},
+		{
+			name:  "lines ignore punctuation by default",
+			words: transcript("yes, we can do it"),
+			want:  "yes, we can do / it",
+		},
+		{
+			name:  "break at punctuation",
+			words: transcript("yes, we can do it"),
+			opts:  func(o *types.SubtitlesOptions) { o.BreakAtPunctuation = true },
+			want:  "yes, / we can do it",
+		},
+		{
+			name:  "lines are filled without balancing",
+			words: transcript("aa bb cc dd ee ff gg"),
+			want:  "aa bb cc dd ee / ff gg",
+		},
+		{
+			name:  "balance lines",
+			words: transcript("aa bb cc dd ee ff gg"),
+			opts:  func(o *types.SubtitlesOptions) { o.BalanceLines = true },
+			want:  "aa bb cc dd / ee ff gg",
+		},
+		{
+			name:  "max cue duration ends a page",
+			words: transcript("one two three four five six"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxLines = 2; o.MaxCueDuration = 1.5 },
+			want:  "one two three / - / four five six / -",
+		},
+		{
+			name:  "full cue is pulled back to punctuation",
+			words: transcript("aa bb cc, dd ee ff gg hh"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxLines = 1; o.BreakAtPunctuation = true },
+			want:  "aa bb cc, / dd ee ff gg hh",
+		},
+		{
+			name:  "full cue is not left ending on an article",
+			words: transcript("aa bb cc the dd ee"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxLines = 1; o.NoBreakAfter = []string{"the"} },
+			want:  "aa bb cc / the dd ee",
+		},
+		{
+			name:  "no words",
+			words: nil,
+			want:  "-",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := lineOpts(14)
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			lines, _ := breakLines(tt.words, opts)
+			if got := layout(lines); got != tt.want {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakLinesMaps(t *testing.T) {
+	opts := lineOpts(14)
+	opts.MaxLines = 2
+	opts.Padding = 0
+	words := transcript("one two three four five six seven")
+	lines, indexLineMap, lineWidthMap, _ := BreakLines(words, basicfont.Face7x13, basicfont.Face7x13, opts)
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4", len(lines))
+	}
+	wantLine := []int{0, 0, 0, 1, 1, 1, 2}
+	for i, want := range wantLine {
+		if indexLineMap[i] != want {
+			t.Errorf("word %d is on line %d, want %d", i, indexLineMap[i], want)
+		}
+	}
+	// each word is measured with the space after it
+	wantWidth := []float64{14 * glyphWidth, 14 * glyphWidth, 6 * glyphWidth, 0}
+	for i, want := range wantWidth {
+		if lineWidthMap[i] != want {
+			t.Errorf("line %d is %v wide, want %v", i, lineWidthMap[i], want)
+		}
+	}
+}
+
+func TestBreakLinesWarnings(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []types.Word
+		opts  func(*types.SubtitlesOptions)
+		want  []string
+	}{
+		{
+			name:  "none",
+			words: transcript("one two three"),
+		},
+		{
+			name:  "word longer than max chars per line",
+			words: transcript("a enormously b"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxCharsPerLine = 5 },
+			want:  []string{`word 1: maxCharsPerLine: "enormously" is longer than 5 characters`},
+		},
+		{
+			name:  "word wider than the frame",
+			words: transcript("a extraordinarily b"),
+			want:  []string{`word 1: width: "extraordinarily" is wider than the frame`},
+		},
+		{
+			name:  "cue shorter than min cue duration",
+			words: transcript("one two three four"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxLines = 1; o.MinCueDuration = 1.2 },
+			want:  []string{"word 3: minCueDuration: cue is shown for 0.40s, less than 1.20s"},
+		},
+		{
+			name: "single word longer than max cue duration",
+			words: func() []types.Word {
+				words := transcript("one two")
+				words[0].Duration = 3
+				words[1].Time = 3
+				return words
+			}(),
+			opts: func(o *types.SubtitlesOptions) { o.MaxCueDuration = 2 },
+			want: []string{"word 0: maxCueDuration: cue is shown for 3.00s, more than 2.00s"},
+		},
+		{
+			name:  "cue faster than max cps",
+			words: transcript("one two three"),
+			opts:  func(o *types.SubtitlesOptions) { o.MaxCPS = 5 },
+			want:  []string{"word 0: maxCPS: cue needs 9.3 characters per second, more than 5.0"},
+		},
+		{
+			name: "last word without a duration is not timed",
+			words: func() []types.Word {
+				words := transcript("one two three")
+				words[2].Duration = 0
+				return words
+			}(),
+			opts: func(o *types.SubtitlesOptions) { o.MaxCPS = 5; o.MinCueDuration = 5 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := lineOpts(14)
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			_, warnings := breakLines(tt.words, opts)
+			var got []string
+			for _, w := range warnings {
+				got = append(got, w.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("warnings = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxCPSDoesNotBreak(t *testing.T) {
+	opts := lineOpts(14)
+	opts.MaxLines = 2
+	words := transcript("one two three four five six seven")
+	want, _ := breakLines(words, opts)
+	opts.MaxCPS = 1
+	got, warnings := breakLines(words, opts)
+	if layout(got) != layout(want) {
+		t.Errorf("lines with maxCPS = %q, want %q", layout(got), layout(want))
+	}
+	if len(warnings) == 0 {
+		t.Error("no maxCPS warning")
+	}
+}
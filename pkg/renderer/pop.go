@@ -0,0 +1,136 @@
+package renderer
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+	"github.com/elweday/go-subtitles/pkg/utils"
+	"github.com/elweday/go-subtitles/pkg/utils/interpolation"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+// LayoutPop shows one word at a time, centred and auto-fitted, instead of
+// paging lines produced by SplitIntoLines.
+const LayoutPop = "pop"
+
+// popHeight is the overlay height used by the pop layout, leaving room for
+// the spring to overshoot.
+func popHeight(opts types.SubtitlesOptions) float64 {
+	return opts.PopFontSize*opts.LineSpacing + 2*float64(opts.Padding)
+}
+
+// overlayHeight returns the height of the frames drawn for opts.Layout.
+func overlayHeight(opts types.SubtitlesOptions) float64 {
+	if opts.Layout == LayoutPop {
+		return popHeight(opts)
+	}
+	return float64(opts.FontSize)*float64(opts.MaxLines)*opts.LineSpacing + 2*float64(opts.Padding)
+}
+
+// FitFontSize returns the largest size up to opts.PopFontSize at which word
+// fits in opts.PopMaxWidth of the frame width.
+func FitFontSize(word string, bFont []byte, opts types.SubtitlesOptions) float64 {
+	drawer := &font.Drawer{Face: utils.ReadFont(bFont, opts.PopFontSize)}
+	wordWidth := float64(drawer.MeasureString(word) >> 6)
+	maxWidth := float64(opts.Width) * opts.PopMaxWidth
+	if wordWidth <= maxWidth || wordWidth == 0 {
+		return opts.PopFontSize
+	}
+	return opts.PopFontSize * maxWidth / wordWidth
+}
+
+// PopItem is a word drawn by the pop layout at its current animation scale.
+type PopItem struct {
+	Word  types.Word
+	Face  font.Face
+	Scale float64
+}
+
+// PopScale returns the entry scale of a word elapsed seconds after it
+// started, and its velocity, driven by opts.PopSpring.
+func PopScale(opts types.SubtitlesOptions, elapsed float64) (float64, float64) {
+	return interpolation.NewSpring(0, 1, 0, popSpring(opts.PopSpring, opts.PopEntryDuration)).At(elapsed)
+}
+
+// PopExit returns the spring taking a word off screen once the next one
+// starts, shown seconds after it entered. It continues from the scale and
+// velocity the entry spring had at that moment.
+func PopExit(opts types.SubtitlesOptions, shown float64) *interpolation.SpringSolver {
+	scale, velocity := PopScale(opts, shown)
+	return interpolation.NewSpring(scale, 0, velocity, popSpring(opts.PopSpring, opts.PopExitDuration))
+}
+
+// popSpring maps the deprecated pop durations onto a spring: when duration
+// is set, it returns a spring as damped as spring that settles in about
+// duration seconds.
+func popSpring(spring types.SpringOptions, duration float64) types.SpringOptions {
+	if duration <= 0 {
+		return spring
+	}
+	mass := utils.Iff(spring.Mass > 0, spring.Mass, 1)
+	stiffness := utils.Iff(spring.Stiffness > 0, spring.Stiffness, 1)
+	zeta := math.Max(spring.Damping, 0) / (2 * math.Sqrt(stiffness*mass))
+	if zeta <= 0 {
+		zeta = 1
+	}
+
+	// the spring settles to within 2% after about 4 time constants of its
+	// slowest decay, which is zeta*omega, or the slow root when over-damped
+	decay := zeta
+	if zeta > 1 {
+		decay = zeta - math.Sqrt(zeta*zeta-1)
+	}
+	omega := 4 / (decay * duration)
+	stiffness = mass * omega * omega
+	return types.SpringOptions{Stiffness: stiffness, Damping: 2 * zeta * math.Sqrt(stiffness*mass), Mass: mass}
+}
+
+// DrawPopFrame draws each item alone in the middle of the frame, in order,
+// so a word still leaving can be passed before the one entering.
+func DrawPopFrame(items []PopItem, perc float64, opts types.SubtitlesOptions, u types.Updater) []byte {
+	u.Update(&opts, perc)
+
+	dc := gg.NewContext(opts.Width, int(frameHeight(opts)))
+	dc.Clear()
+
+	for _, item := range items {
+		word := item.Word
+		wopts, wordScale := applyWordStyle(applySpeakerStyle(opts, word.Speaker), word.Style)
+		scale := item.Scale * wordScale
+		if scale <= 0 {
+			continue
+		}
+		dc.SetFontFace(item.Face)
+
+		cx := float64(wopts.Width)/2 + wopts.TextOffsetX
+		cy := blockTop(wopts, word.Speaker) + popHeight(wopts)/2 + wopts.TextOffsetY
+		wordWidth, wordHeight := dc.MeasureString(word.Value)
+		x := cx - wordWidth/2
+		y := cy + wordHeight/2
+
+		dc.Push()
+		dc.ScaleAbout(scale, scale, cx, cy)
+		if wopts.Reveal {
+			drawRevealed(dc, word.Value, wopts.FontSelectedColor, x, y, wopts.RevealProgress, wopts.CursorVisible, wopts)
+		} else {
+			dc.SetHexColor(wopts.FontSelectedColor)
+			dc.DrawString(word.Value, x, y)
+		}
+		drawEmoji(dc, word.Style, cx, y-wordHeight)
+		dc.Pop()
+	}
+
+	fadeImage(dc, opts.TextOpacity)
+	return encodePNG(dc)
+}
+
+func encodePNG(dc *gg.Context) []byte {
+	var buf bytes.Buffer
+	if err := dc.EncodePNG(&buf); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
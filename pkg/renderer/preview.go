@@ -0,0 +1,181 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os/exec"
+	"strconv"
+
+	"github.com/elweday/go-subtitles/pkg/utils"
+
+	"github.com/fogleman/gg"
+	xdraw "golang.org/x/image/draw"
+)
+
+// DefaultTileWidth is the width of a contact sheet tile when none is given.
+const DefaultTileWidth = 480
+
+// sheetGap is the space around the tiles of a contact sheet.
+const sheetGap = 8
+
+// MaxSheetPixels caps the size of a contact sheet, which takes 4 bytes a
+// pixel in memory.
+const MaxSheetPixels = 32 << 20
+
+// FFmpegExtractFrame decodes the frame of the video at inputPath shown at t
+// seconds.
+func FFmpegExtractFrame(ctx context.Context, inputPath string, t float64) (image.Image, error) {
+	// seeking before the input jumps to the nearest keyframe and decodes
+	// from there, which is exact and much faster than seeking after it
+	args := []string{"-hide_banner", "-ss", strconv.FormatFloat(t, 'f', 3, 64)}
+	args = append(args, inputArgs(inputPath)...)
+	args = append(args, "-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "-")
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stderr := newRingBuffer(stderrBufferSize)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newFFmpegError(cmd, err, stderr)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("video has no frame at %.3fs", t)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode frame at %.3fs: %v", t, err)
+	}
+	return img, nil
+}
+
+// SheetTimes spreads count timestamps evenly over the captions of vid, or
+// over the whole video when it has no words.
+func (vid *VidoePayload) SheetTimes(count int) []float64 {
+	start, end := 0.0, vid.Opts.Duration
+	if n := len(vid.Words); n > 0 {
+		start = vid.Words[0].Time
+		end = vid.Words[n-1].Time + vid.Words[n-1].Duration
+		if vid.Opts.Duration > 0 {
+			end = min(end, vid.Opts.Duration)
+		}
+	}
+	times := make([]float64, count)
+	for i := range times {
+		// the middle of each of count equal spans
+		times[i] = start + (end-start)*(float64(i)+0.5)/float64(count)
+	}
+	return times
+}
+
+// Preview draws the captions shown at t seconds over the matching frame of
+// the video, placed where the render would overlay them.
+func (vid *VidoePayload) Preview(ctx context.Context, t float64) (image.Image, error) {
+	d, lineIndexMap, err := vid.newDrawer()
+	if err != nil {
+		return nil, err
+	}
+	return vid.preview(ctx, d, lineIndexMap, t)
+}
+
+func (vid *VidoePayload) preview(ctx context.Context, d *frameDrawer, lineIndexMap map[int]int, t float64) (image.Image, error) {
+	frame, err := FFmpegExtractFrame(ctx, vid.InputPath, t)
+	if err != nil {
+		return nil, err
+	}
+	index := int(math.Floor(t*vid.Opts.FPS + 1e-9))
+	overlay, err := png.Decode(bytes.NewReader(d.draw(stateAt(vid.Words, lineIndexMap, vid.Opts, index, t))))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode caption overlay: %v", err)
+	}
+
+	img := image.NewRGBA(frame.Bounds())
+	draw.Draw(img, img.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	offset := utils.Iff(usesFullFrame(vid.Opts), 0, captionOffset(vid.Opts, vid.Opts.Alignment))
+	at := img.Bounds().Min.Add(image.Pt(0, int(math.Round(offset))))
+	draw.Draw(img, overlay.Bounds().Add(at), overlay, overlay.Bounds().Min, draw.Over)
+	return img, nil
+}
+
+// sheetLayout returns the grid and tile size of a contact sheet of n tiles.
+// Tiles are never wider than the video.
+func (vid *VidoePayload) sheetLayout(n, columns, tileWidth int) (cols, rows, width, height int) {
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(n))))
+	}
+	cols = max(min(columns, n), 1)
+	rows = (n + cols - 1) / cols
+	if tileWidth <= 0 {
+		tileWidth = DefaultTileWidth
+	}
+	width = min(tileWidth, vid.Opts.Width)
+	height = int(math.Round(float64(width) * float64(vid.Opts.Height) / float64(vid.Opts.Width)))
+	return cols, rows, width, height
+}
+
+// SheetSize returns the size of the contact sheet ContactSheet makes of n
+// tiles.
+func (vid *VidoePayload) SheetSize(n, columns, tileWidth int) image.Point {
+	cols, rows, width, height := vid.sheetLayout(n, columns, tileWidth)
+	return image.Pt(cols*(width+sheetGap)+sheetGap, rows*(height+sheetGap)+sheetGap)
+}
+
+// ContactSheet tiles the previews at times into one image, columns tiles
+// per row and each tileWidth wide, labelled with its timestamp. Zero columns
+// makes a roughly square grid, and zero tileWidth uses DefaultTileWidth.
+// Sheets over MaxSheetPixels are refused.
+func (vid *VidoePayload) ContactSheet(ctx context.Context, times []float64, columns, tileWidth int) (image.Image, error) {
+	if len(times) == 0 {
+		return nil, fmt.Errorf("contact sheet needs at least one timestamp")
+	}
+	size := vid.SheetSize(len(times), columns, tileWidth)
+	if size.X*size.Y > MaxSheetPixels {
+		return nil, fmt.Errorf("contact sheet of %dx%d pixels is larger than %d pixels", size.X, size.Y, MaxSheetPixels)
+	}
+	columns, _, tileWidth, tileHeight := vid.sheetLayout(len(times), columns, tileWidth)
+
+	d, lineIndexMap, err := vid.newDrawer()
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.RGBA{24, 24, 24, 255}), image.Point{}, draw.Src)
+	dc := gg.NewContextForRGBA(sheet)
+	labelSize := max(12, float64(tileHeight)/24)
+	dc.SetFontFace(utils.ReadFont(d.regFont, labelSize))
+
+	for i, t := range times {
+		img, err := vid.preview(ctx, d, lineIndexMap, t)
+		if err != nil {
+			return nil, err
+		}
+		x := sheetGap + (i%columns)*(tileWidth+sheetGap)
+		y := sheetGap + (i/columns)*(tileHeight+sheetGap)
+		tile := image.Rect(x, y, x+tileWidth, y+tileHeight)
+		xdraw.ApproxBiLinear.Scale(sheet, tile, img, img.Bounds(), draw.Src, nil)
+
+		label := formatTimestamp(t)
+		w, h := dc.MeasureString(label)
+		pad := labelSize / 3
+		dc.SetRGBA(0, 0, 0, 0.6)
+		dc.DrawRectangle(float64(x), float64(y), w+2*pad, h+2*pad)
+		dc.Fill()
+		dc.SetRGB(1, 1, 1)
+		dc.DrawStringAnchored(label, float64(x)+pad, float64(y)+pad, 0, 1)
+	}
+	return sheet, nil
+}
+
+// formatTimestamp writes t seconds as minutes and seconds, e.g. 1:02.50.
+func formatTimestamp(t float64) string {
+	return fmt.Sprintf("%d:%05.2f", int(t)/60, math.Mod(t, 60))
+}
@@ -0,0 +1,164 @@
+package renderer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// VideoMetadata is what the renderer needs to know about an input video.
+type VideoMetadata struct {
+	Width         int    // displayed width, after rotation
+	Height        int    // displayed height, after rotation
+	DisplayAspect string // e.g. "16:9", empty when unknown
+	Rotation      int    // clockwise rotation in degrees, one of 0, 90, 180, 270
+	AvgFrameRate  float64
+	RealFrameRate float64
+	Duration      float64 // seconds
+	HasAudio      bool
+	AudioCodecs   []string // codec of each audio stream, e.g. "aac"
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType          string            `json:"codec_type"`
+		CodecName          string            `json:"codec_name"`
+		Width              int               `json:"width"`
+		Height             int               `json:"height"`
+		DisplayAspectRatio string            `json:"display_aspect_ratio"`
+		AvgFrameRate       string            `json:"avg_frame_rate"`
+		RFrameRate         string            `json:"r_frame_rate"`
+		Duration           string            `json:"duration"`
+		Tags               map[string]string `json:"tags"`
+		SideDataList       []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// FFprobeMetadata reads the size, rotation, frame rate, duration and audio
+// presence of a video file or URL with ffprobe.
+func FFprobeMetadata(ctx context.Context, inputPath string) (*VideoMetadata, error) {
+	args := []string{"-v", "error", "-show_streams", "-show_format", "-of", "json"}
+	if isURL(inputPath) {
+		args = append(args, "-protocol_whitelist", urlProtocols)
+	}
+	cmd := exec.CommandContext(ctx, "ffprobe", append(args, inputPath)...)
+
+	stderr := newRingBuffer(stderrBufferSize)
+	cmd.Stderr = stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newFFmpegError(cmd, err, stderr)
+	}
+
+	return parseFFprobeOutput(output)
+}
+
+func parseFFprobeOutput(output []byte) (*VideoMetadata, error) {
+	probe := ffprobeOutput{}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe output: %v", err)
+	}
+
+	meta := &VideoMetadata{}
+	foundVideo := false
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "audio":
+			meta.HasAudio = true
+			meta.AudioCodecs = append(meta.AudioCodecs, stream.CodecName)
+		case "video":
+			if foundVideo {
+				continue
+			}
+			foundVideo = true
+			meta.Width = stream.Width
+			meta.Height = stream.Height
+			meta.DisplayAspect = stream.DisplayAspectRatio
+			meta.AvgFrameRate = parseRate(stream.AvgFrameRate)
+			meta.RealFrameRate = parseRate(stream.RFrameRate)
+			meta.Duration, _ = strconv.ParseFloat(stream.Duration, 64)
+
+			rotation := 0.0
+			if rotate, ok := stream.Tags["rotate"]; ok {
+				rotation, _ = strconv.ParseFloat(rotate, 64)
+			}
+			for _, side := range stream.SideDataList {
+				if side.Rotation != 0 {
+					// the display matrix rotates counter-clockwise
+					rotation = -side.Rotation
+				}
+			}
+			meta.Rotation = ((int(math.Round(rotation))%360 + 360) % 360)
+		}
+	}
+	if !foundVideo {
+		return nil, fmt.Errorf("no video stream found")
+	}
+
+	if duration, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		meta.Duration = duration
+	}
+
+	// ffmpeg rotates the frames when decoding, so the overlay has to match
+	if meta.Rotation == 90 || meta.Rotation == 270 {
+		meta.Width, meta.Height = meta.Height, meta.Width
+		if w, h, ok := strings.Cut(meta.DisplayAspect, ":"); ok {
+			meta.DisplayAspect = h + ":" + w
+		}
+	}
+
+	return meta, nil
+}
+
+// parseRate parses ffprobe rates such as "30000/1001". Unknown rates, which
+// ffprobe reports as "0/0", are 0.
+func parseRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		v, _ := strconv.ParseFloat(rate, 64)
+		return v
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// FrameRate returns the rate overlay frames are drawn at: the average frame
+// rate, or the real base rate for streams that don't report one. It is not
+// rounded, so captions keep in step with 29.97 or 23.976 fps video.
+func (meta *VideoMetadata) FrameRate() float64 {
+	rate := meta.AvgFrameRate
+	if rate <= 0 {
+		rate = meta.RealFrameRate
+	}
+	return rate
+}
+
+// Apply copies the probed size, frame rate and duration into opts. The frame
+// rate is kept when the video doesn't report one.
+func (meta *VideoMetadata) Apply(opts *types.SubtitlesOptions) {
+	opts.Width = meta.Width
+	opts.Height = meta.Height
+	opts.Duration = meta.Duration
+	if fps := meta.FrameRate(); fps > 0 {
+		opts.FPS = fps
+	}
+}
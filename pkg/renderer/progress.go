@@ -0,0 +1,184 @@
+package renderer
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Render stages reported in Progress.
+const (
+	StageDrawing  = "drawing"
+	StageEncoding = "encoding"
+	StageDone     = "done"
+)
+
+// progressInterval is the shortest time between two progress reports within
+// a stage.
+const progressInterval = 250 * time.Millisecond
+
+// Progress is a snapshot of a running render.
+type Progress struct {
+	Stage         string  `json:"stage" firestore:"stage"`
+	TotalFrames   int     `json:"totalFrames" firestore:"totalFrames"`
+	FramesDrawn   int     `json:"framesDrawn" firestore:"framesDrawn"`
+	FramesEncoded int     `json:"framesEncoded" firestore:"framesEncoded"`
+	Elapsed       float64 `json:"elapsed" firestore:"elapsed"` // seconds
+	ETA           float64 `json:"eta" firestore:"eta"`         // seconds, 0 until it can be estimated
+}
+
+// ProgressFunc receives progress reports. It is called from a goroutine of
+// the render, one call at a time, and the last call returns before the
+// render does. Reports made while it is busy are skipped.
+type ProgressFunc func(Progress)
+
+// progressTracker counts drawn and encoded frames and reports them at most
+// every progressInterval, and always when the stage changes. Reports are
+// made from a goroutine of their own, so a slow ProgressFunc doesn't hold up
+// drawing; while it is busy only the latest snapshot is kept.
+type progressTracker struct {
+	mu       sync.Mutex
+	report   ProgressFunc
+	start    time.Time
+	last     time.Time
+	progress Progress
+	pending  chan Progress // the latest snapshot not yet reported
+	finished chan struct{} // closed when the last report returns
+	stopped  bool
+}
+
+func newProgressTracker(report ProgressFunc, total int) *progressTracker {
+	t := &progressTracker{
+		report:   report,
+		start:    time.Now(),
+		progress: Progress{Stage: StageDrawing, TotalFrames: total},
+		pending:  make(chan Progress, 1),
+		finished: make(chan struct{}),
+	}
+	if report == nil {
+		close(t.finished)
+		return t
+	}
+	go func() {
+		defer close(t.finished)
+		for p := range t.pending {
+			t.report(p)
+		}
+	}()
+	return t
+}
+
+// stop delivers the last snapshot and waits for it to be reported. Later
+// updates are dropped.
+func (t *progressTracker) stop() {
+	t.mu.Lock()
+	if !t.stopped {
+		t.stopped = true
+		close(t.pending)
+	}
+	t.mu.Unlock()
+	<-t.finished
+}
+
+func (t *progressTracker) frameDrawn() {
+	t.update(func(p *Progress) { p.FramesDrawn++ })
+}
+
+func (t *progressTracker) framesEncoded(n int) {
+	t.update(func(p *Progress) {
+		p.Stage = StageEncoding
+		p.FramesEncoded = min(n, p.TotalFrames)
+	})
+}
+
+func (t *progressTracker) done() {
+	t.update(func(p *Progress) {
+		p.Stage = StageDone
+		p.FramesEncoded = p.TotalFrames
+	})
+}
+
+func (t *progressTracker) update(f func(p *Progress)) {
+	if t == nil || t.report == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+
+	stage := t.progress.Stage
+	f(&t.progress)
+	now := time.Now()
+	if t.progress.Stage == stage && now.Sub(t.last) < progressInterval {
+		return
+	}
+	t.last = now
+
+	p := t.progress
+	p.Elapsed = now.Sub(t.start).Seconds()
+	// drawing and encoding a frame are counted as one unit of work each
+	total := 2 * p.TotalFrames
+	done := p.FramesDrawn + p.FramesEncoded
+	if done > 0 && done < total {
+		p.ETA = p.Elapsed * float64(total-done) / float64(done)
+	}
+	// replace a snapshot the reporter hasn't picked up yet
+	select {
+	case <-t.pending:
+	default:
+	}
+	t.pending <- p
+}
+
+// progressWriter splits the stderr of an ffmpeg run with -nostats and
+// -progress pipe:2, which keeps the carriage-return stats lines out of it.
+// Progress lines are parsed for the encoded frame count and the rest of the
+// log is passed on to log.
+type progressWriter struct {
+	log     *ringBuffer
+	onFrame func(frames int)
+	partial []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) line(line []byte) {
+	key, value, ok := bytes.Cut(bytes.TrimSpace(line), []byte("="))
+	if !ok || !isProgressKey(key) {
+		w.log.Write(line)
+		return
+	}
+	if string(key) == "frame" && w.onFrame != nil {
+		if n, err := strconv.Atoi(string(value)); err == nil {
+			w.onFrame(n)
+		}
+	}
+}
+
+// isProgressKey reports whether key looks like a -progress key, which are
+// lowercase words joined by underscores.
+func isProgressKey(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for _, c := range key {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
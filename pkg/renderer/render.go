@@ -1,12 +1,16 @@
 package renderer
 
 import (
-	"bytes"
+	"context"
 	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
-	"sync"
 
 	"github.com/elweday/go-subtitles/pkg/styles"
 	"github.com/elweday/go-subtitles/pkg/types"
@@ -17,50 +21,15 @@ import (
 )
 
 func SplitIntoLines(words []types.Word, bFont []byte, opts types.SubtitlesOptions) ([][]types.Word, map[int]int, map[int]float64) {
-
-	indexLineMap := map[int]int{}
-	lineWidthMap := map[int]float64{}
-
-	fontFace := utils.ReadFont(bFont, opts.FontSize)
-	maxWidth := float64(opts.Width)
-
-	currWidth := float64(opts.Padding)
-	current := []types.Word{}
-	result := [][]types.Word{}
-	drawer := &font.Drawer{
-		Face: fontFace,
-	}
-	sep := strings.Repeat(" ", opts.WordSpacing)
-	spaceWidth := float64(drawer.MeasureString(sep) >> 6)
-	lineIndex := 0
-
-	for i, word := range words {
-		indexLineMap[i] = lineIndex
-		wordWidth := float64(drawer.MeasureString(word.Value) >> 6)
-
-		if currWidth+wordWidth+spaceWidth+float64(opts.Padding) > maxWidth-float64(opts.Padding) {
-			result = append(result, current)
-			current = []types.Word{}
-			lineWidthMap[lineIndex] = currWidth
-			lineIndex += 1
-			currWidth = float64(opts.Padding)
-		}
-
-		current = append(current, word)
-
-		currWidth += wordWidth + spaceWidth
-
-	}
-	result = append(result, current)
-
-	return result, indexLineMap, lineWidthMap
+	face := utils.ReadFont(bFont, opts.FontSize)
+	lines, indexLineMap, lineWidthMap, _ := BreakLines(words, face, face, opts)
+	return lines, indexLineMap, lineWidthMap
 }
 
 func DrawFrame2(lines [][]types.Word, widths []float64, idx int, perc float64, opts types.SubtitlesOptions, u types.Updater, regFont, boldFont font.Face) []byte {
 	u.Update(&opts, perc)
 
-	height := float64(opts.FontSize)*float64(opts.MaxLines)*opts.LineSpacing + 2*float64(opts.Padding)
-	dc := gg.NewContext(opts.Width, int(height))
+	dc := gg.NewContext(opts.Width, int(frameHeight(opts)))
 
 	dc.Clear()
 
@@ -68,8 +37,8 @@ func DrawFrame2(lines [][]types.Word, widths []float64, idx int, perc float64, o
 
 	dc.SetFontFace(regFont)
 
-	currWidth := utils.Iff(opts.Center, (float64(opts.Width)-widths[0])/2, 0)
-	currHeight := float64(opts.Padding)
+	currWidth := 0.0
+	currHeight := float64(opts.Padding) + blockTop(opts, pageSpeaker(lines))
 	sep := strings.Repeat(" ", opts.WordSpacing)
 	spaceWidth, _ := dc.MeasureString(sep)
 	startX := utils.Iff(opts.RTL, opts.Width-opts.Padding, opts.Padding)
@@ -78,17 +47,47 @@ func DrawFrame2(lines [][]types.Word, widths []float64, idx int, perc float64, o
 	lineHeight := opts.FontSize
 
 	dc.SetHexColor(opts.FontColor)
+	activeLine := lineOfIndex(lines, idx)
+
+	// with the line scope the active line is typed out over its whole
+	// duration, so its words share the graphemes typed so far
+	lineScope := opts.Reveal && opts.TypewriterScope == "line"
+	typed := 0
+	if lineScope && activeLine >= 0 {
+		total := 0
+		for _, word := range lines[activeLine] {
+			total += len(utils.Graphemes(word.Value))
+		}
+		typed = int(math.Ceil(opts.RevealProgress * float64(total)))
+	}
+
 	c := 0
 	for i, line := range lines {
-		lineStart := 0.0
-		lineWidth := widths[i]
-		if opts.Center {
-			lineStart = (float64(opts.Width) - lineWidth) / 2
+		if len(line) > 0 {
+			currWidth = lineStartOffset(opts, line[0].Speaker, widths[i], spaceWidth)
 		}
-		for _, word := range line {
+		before := 0 // graphemes of the active line ahead of the word
+		for j, word := range line {
 			c++
+			progress, cursor := opts.RevealProgress, opts.CursorVisible
+			if lineScope && i == activeLine {
+				n := len(utils.Graphemes(word.Value))
+				progress = float64(typed-before) / float64(max(n, 1))
+				// the cursor follows the last typed grapheme of the line
+				cursor = cursor && typed >= before && (typed < before+n || j == len(line)-1)
+				before += n
+			}
+			wopts, scale := applyWordStyle(applySpeakerStyle(opts, word.Speaker), word.Style)
+			// the word takes the room BreakLines measured for it, and is
+			// scaled and highlighted about the middle of it
+			layoutFace := utils.Iff(word.Style != nil && word.Style.Bold, boldFont, regFont)
+			dc.SetFontFace(layoutFace)
+			layoutWidth, _ := dc.MeasureString(word.Value)
+			slot := layoutWidth * scale
+			middle := float64(startX) + currWidth*dir + utils.Iff(opts.RTL, -slot/2, slot/2)
+			dc.SetFontFace(utils.Iff(c == idx, boldFont, layoutFace))
 			wordWidth, _ := dc.MeasureString(word.Value)
-			wordX := float64(startX) + float64(currWidth)*dir + utils.Iff(opts.RTL, -wordWidth, 0)
+			wordX := middle - wordWidth/2
 			wordY := float64(startY) + float64(currHeight)
 			x := wordX - opts.HighlightPadding + opts.TextOffsetX
 			y := wordY - lineHeight - opts.HighlightPadding + opts.TextOffsetY + (opts.FontSize * 0.23)
@@ -98,35 +97,108 @@ func DrawFrame2(lines [][]types.Word, widths []float64, idx int, perc float64, o
 			cy := y + h/2
 
 			if c == idx {
-				dc.SetFontFace(boldFont)
 				dc.Push()
-				dc.SetHexColor(opts.HighlightColor)
-				dc.ScaleAbout(opts.HighlightScale, opts.HighlightScale, cx, cy)
+				dc.SetHexColor(wopts.HighlightColor)
+				dc.ScaleAbout(opts.HighlightScale*scale, opts.HighlightScale*scale, cx, cy)
 				dc.DrawRoundedRectangle(x, y, w, h, float64(opts.HighlightBorderRadius))
 				dc.Fill()
-				dc.SetHexColor(opts.FontSelectedColor)
+				dc.SetHexColor(wopts.FontSelectedColor)
 				dc.Stroke()
-				dc.DrawString(word.Value, wordX+opts.TextOffsetX, wordY+opts.TextOffsetY)
+				if opts.Reveal {
+					drawRevealed(dc, word.Value, wopts.FontSelectedColor, wordX+opts.TextOffsetX, wordY+opts.TextOffsetY, progress, cursor, opts)
+				} else {
+					dc.DrawString(word.Value, wordX+opts.TextOffsetX, wordY+opts.TextOffsetY)
+				}
+				drawEmoji(dc, word.Style, cx, y)
+				dc.Pop()
+			} else if lineScope && i == activeLine && progress < 1 {
+				// words of the line being typed that are not typed out yet
+				dc.Push()
+				dc.ScaleAbout(scale, scale, cx, cy)
+				drawRevealed(dc, word.Value, wopts.FontColor, wordX, wordY, progress, cursor, opts)
 				dc.Pop()
 			} else {
-				dc.SetFontFace(regFont)
-				dc.SetHexColor(opts.FontColor)
+				dc.Push()
+				dc.ScaleAbout(scale, scale, cx, cy)
+				dc.SetHexColor(wopts.FontColor)
 				dc.DrawString(word.Value, wordX, wordY)
+				drawEmoji(dc, word.Style, cx, y)
+				dc.Pop()
 			}
 
-			currWidth += wordWidth + spaceWidth
+			currWidth += slot + spaceWidth
 		}
-		currWidth = lineStart
 		currHeight += lineHeight * opts.LineSpacing
 
 	}
 
-	var buf bytes.Buffer
-	if err := dc.EncodePNG(&buf); err != nil {
-		panic(err)
+	fadeImage(dc, opts.TextOpacity)
+	return encodePNG(dc)
+}
+
+// drawRevealed draws the fraction progress of s spoken so far. The rest is
+// ghosted at opts.GhostOpacity (or hidden when it is 0) and the cursor, if
+// shown, follows the last revealed character.
+func drawRevealed(dc *gg.Context, s string, hexColor string, x, y, progress float64, cursor bool, opts types.SubtitlesOptions) {
+	n := int(math.Ceil(progress * float64(len(utils.Graphemes(s)))))
+	shown, hidden := utils.RevealText(s, n)
+	shownWidth, _ := dc.MeasureString(shown)
+	hiddenWidth, _ := dc.MeasureString(hidden)
+
+	rtl := utils.IsVisualRTL(s)
+	shownX, hiddenX := x, x+shownWidth
+	if rtl {
+		hiddenX, shownX = x, x+hiddenWidth
+	}
+
+	if hidden != "" && opts.GhostOpacity > 0 {
+		dc.SetColor(utils.HexColorWithOpacity(hexColor, opts.GhostOpacity))
+		dc.DrawString(hidden, hiddenX, y)
 	}
+	dc.SetHexColor(hexColor)
+	dc.DrawString(shown, shownX, y)
 
-	return buf.Bytes()
+	if cursor {
+		cursorWidth, _ := dc.MeasureString(opts.TypewriterCursor)
+		dc.DrawString(opts.TypewriterCursor, utils.Iff(rtl, shownX-cursorWidth, shownX+shownWidth), y)
+	}
+}
+
+// applyWordStyle returns opts with the colours overridden by the word's own
+// style, if any, and the extra scale the word is drawn at.
+func applyWordStyle(opts types.SubtitlesOptions, style *types.WordStyle) (types.SubtitlesOptions, float64) {
+	if style == nil {
+		return opts, 1
+	}
+	if style.Color != "" {
+		opts.FontColor = style.Color
+		opts.FontSelectedColor = style.Color
+	}
+	if style.HighlightColor != "" {
+		opts.HighlightColor = style.HighlightColor
+	}
+	return opts, utils.Iff(style.Scale > 0, style.Scale, 1)
+}
+
+// drawEmoji draws the word's decoration centred above (cx, top). The caption
+// font has to contain the glyphs for it to show up.
+func drawEmoji(dc *gg.Context, style *types.WordStyle, cx, top float64) {
+	if style == nil || style.Emoji == "" {
+		return
+	}
+	dc.DrawStringAnchored(style.Emoji, cx, top, 0.5, 0)
+}
+
+// lineOfIndex returns the line holding the 1-based word index idx.
+func lineOfIndex(lines [][]types.Word, idx int) int {
+	sum := 0
+	for i, line := range lines {
+		sum += len(line)
+		if idx <= sum {
+			return i
+		}
+	}
+	return len(lines) - 1
 }
 
 func calcRelativeIndex(lines [][]types.Word, lineIndex, index int) int {
@@ -138,12 +210,17 @@ func calcRelativeIndex(lines [][]types.Word, lineIndex, index int) int {
 }
 
 type VidoePayload struct {
-	InputVideoObj  string                 `firestore:"inputVideo"`
-	OutputVideoObj string                 `firestore:"outputVideo"`
-	Words          []types.Word           `firestore:"words"`
-	Opts           types.SubtitlesOptions `firestore:"opts"`
-	InputVideo     []byte
-	OutputVideo    []byte
+	InputVideoObj        string                 `firestore:"inputVideo"`
+	OutputVideoObj       string                 `firestore:"outputVideo"`
+	Words                []types.Word           `firestore:"words"`
+	Opts                 types.SubtitlesOptions `firestore:"opts"`
+	InputPath            string                 `firestore:"-"` // file or URL the video is read from
+	Output               io.Writer              `firestore:"-"` // receives the rendered video
+	Metadata             VideoMetadata          `firestore:"-"`
+	ReplacementAudioPath string                 `firestore:"-"`
+	BackgroundMusicPath  string                 `firestore:"-"`
+	Warnings             []LineBreakWarning     `firestore:"-"` // set when the captions are laid out
+	Progress             ProgressFunc           `firestore:"-"`
 }
 
 func getLineWidths(m map[int]float64, start int, end int) []float64 {
@@ -154,15 +231,88 @@ func getLineWidths(m map[int]float64, start int, end int) []float64 {
 	return widths
 }
 
-func (vid *VidoePayload) RenderWithSubtitles() error {
+// frameDrawer draws the caption overlay for a FrameState.
+type frameDrawer struct {
+	opts              types.SubtitlesOptions
+	words             []types.Word
+	lines             [][]types.Word
+	lineWidths        map[int]float64
+	popSizes          map[int]float64
+	regFont, boldFont []byte
+	updater           types.Updater
+	blank             []byte
+}
 
-	// fontMap, err := GetFontWeightMapFromGoogle(opts.FontFamily, "arabic")
+func (d *frameDrawer) draw(st FrameState) []byte {
+	if st.Blank {
+		return d.blank
+	}
 
-	/* if err != nil {
-		fmt.Printf(err.Error(), "font not found")
+	opts := d.opts
+	opts.Time = st.Time
+	opts.WordElapsed = st.Elapsed
+	opts.WordProgress = st.Progress
+	opts.LineProgress = st.LineProgress
+	opts.TextOpacity = st.Opacity
+
+	if opts.Layout == LayoutPop {
+		items := []PopItem{}
+		if prev := st.Word - 1; prev >= 0 && !IsPhraseBreak(d.words, prev, opts) {
+			// the previous word keeps springing out until it comes to rest
+			exit := PopExit(opts, d.words[st.Word].Time-d.words[prev].Time)
+			if !exit.Settled(st.Elapsed) {
+				if scale := exit.Value(st.Elapsed); scale > 0 {
+					items = append(items, PopItem{d.words[prev], utils.ReadFont(d.boldFont, d.popSizes[prev]), scale})
+				}
+			}
+		}
+		entry, _ := PopScale(opts, st.Elapsed)
+		items = append(items, PopItem{d.words[st.Word], utils.ReadFont(d.boldFont, d.popSizes[st.Word]), entry})
+		return DrawPopFrame(items, st.Perc, opts, d.updater)
+	}
+
+	startLine := st.Page
+	endLine := min(startLine+opts.MaxLines, len(d.lines))
+	widths := getLineWidths(d.lineWidths, startLine, endLine)
+	relativeIndex := calcRelativeIndex(d.lines, startLine, st.Word) + 1
+	reg := utils.ReadFont(d.regFont, opts.FontSize)
+	bold := utils.ReadFont(d.boldFont, opts.FontSize)
+
+	return DrawFrame2(d.lines[startLine:endLine], widths, relativeIndex, st.Perc, opts, d.updater, reg, bold)
+}
+
+// fadeImage multiplies the opacity of everything drawn on dc by opacity.
+func fadeImage(dc *gg.Context, opacity float64) {
+	img, ok := dc.Image().(*image.RGBA)
+	if !ok || opacity <= 0 || opacity >= 1 {
 		return
 	}
-	*/
+	for i := range img.Pix {
+		img.Pix[i] = uint8(float64(img.Pix[i]) * opacity)
+	}
+}
+
+// ContentType returns the MIME type of the rendered video. It is known
+// before rendering, so it can be sent ahead of the streamed output.
+func (vid *VidoePayload) ContentType() string {
+	return ContentType(vid.container())
+}
+
+// FileExtension returns the file extension of the rendered video.
+func (vid *VidoePayload) FileExtension() string {
+	return FileExtension(vid.container())
+}
+
+func (vid *VidoePayload) container() string {
+	enc, err := ResolveEncoding(vid.Opts.Encoding)
+	if err != nil {
+		return vid.Opts.Encoding.Container
+	}
+	return enc.Container
+}
+
+// readFonts reads the regular and bold caption fonts.
+func readFonts() ([]byte, []byte, error) {
 	PREFIX := "serverless_function_source_code"
 	if os.Getenv("GO_ENVIRONMENT") == "DEV" {
 		PREFIX = ""
@@ -170,83 +320,141 @@ func (vid *VidoePayload) RenderWithSubtitles() error {
 	regFont, err1 := os.ReadFile(filepath.Join(PREFIX, "assets", "fonts", "Montserrat-Medium.ttf"))
 	boldFont, err2 := os.ReadFile(filepath.Join(PREFIX, "assets", "fonts", "Montserrat-Bold.ttf"))
 	if err1 != nil || err2 != nil {
-		return fmt.Errorf("failed to read fonts: %v, %v", err1, err2)
+		return nil, nil, fmt.Errorf("failed to read fonts: %v, %v", err1, err2)
 	}
+	return regFont, boldFont, nil
+}
 
-	lines, lineIndexMap, lineWidthMap := SplitIntoLines(vid.Words, regFont, vid.Opts)
-	// fmt.Println(lines)
-
-	var wg sync.WaitGroup
-
-	updater := styles.ScrollingBox{}
+// LineBreakWarnings lays out words as a render with opts would and returns
+// the line-breaking rules it could not keep. The pop layout has no lines.
+func LineBreakWarnings(words []types.Word, opts types.SubtitlesOptions) ([]LineBreakWarning, error) {
+	if opts.Layout == LayoutPop {
+		return nil, nil
+	}
+	regFont, boldFont, err := readFonts()
+	if err != nil {
+		return nil, err
+	}
+	_, _, _, warnings := BreakLines(words, utils.ReadFont(regFont, opts.FontSize), utils.ReadFont(boldFont, opts.FontSize), opts)
+	return warnings, nil
+}
 
-	durationS := 0.2
-	duration := int64(durationS * float64(vid.Opts.FPS))
+// newDrawer reads the fonts and lays out the captions of vid. It also
+// returns the line of every word, which is nil for the pop layout.
+func (vid *VidoePayload) newDrawer() (*frameDrawer, map[int]int, error) {
+	regFont, boldFont, err := readFonts()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	m := map[int][]byte{}
-	mu := sync.Mutex{}
+	lines, lineIndexMap, lineWidthMap := [][]types.Word{}, map[int]int{}, map[int]float64{}
+	popSizes := map[int]float64{}
+	if vid.Opts.Layout == LayoutPop {
+		lineIndexMap = nil
+		for i, word := range vid.Words {
+			popSizes[i] = FitFontSize(word.Value, boldFont, vid.Opts)
+		}
+	} else {
+		lines, lineIndexMap, lineWidthMap, vid.Warnings = BreakLines(vid.Words, utils.ReadFont(regFont, vid.Opts.FontSize), utils.ReadFont(boldFont, vid.Opts.FontSize), vid.Opts)
+		for _, w := range vid.Warnings {
+			log.Printf("line breaking: %s\n", w)
+		}
+	}
 
-	frameCount := 0
-	for iWord := 1; iWord < len(vid.Words); iWord++ {
-		current := vid.Words[iWord]
-		prev := vid.Words[iWord-1]
-		frames := current.Frames - prev.Frames
-		for j := int64(0); j < frames; j++ {
+	d := &frameDrawer{
+		opts:       vid.Opts,
+		words:      vid.Words,
+		lines:      lines,
+		lineWidths: lineWidthMap,
+		popSizes:   popSizes,
+		regFont:    regFont,
+		boldFont:   boldFont,
+		updater:    styles.Get(vid.Opts.Style),
+	}
+	d.blank = encodePNG(gg.NewContext(vid.Opts.Width, int(frameHeight(vid.Opts))))
+	return d, lineIndexMap, nil
+}
 
-			nframes := min(frames, duration)
-			perc := float64(j) / float64(nframes)
-			perc = min(perc, 1.0)
+// RenderWithSubtitles draws the captions, encodes them over the video at
+// InputPath and writes the result to Output as it is encoded. Drawing stops
+// and ffmpeg is killed when ctx is cancelled.
+func (vid *VidoePayload) RenderWithSubtitles(ctx context.Context) error {
 
-			wg.Add(1)
+	// fontMap, err := GetFontWeightMapFromGoogle(opts.FontFamily, "arabic")
 
-			go func(c int, idx int) {
-				defer wg.Done()
-				startLine := lineIndexMap[idx] - (lineIndexMap[idx] % vid.Opts.MaxLines)
-				endLine := startLine + vid.Opts.MaxLines
-				if endLine > len(lines) {
-					endLine = len(lines)
-				}
-				widths := getLineWidths(lineWidthMap, startLine, endLine)
-				selectedlines := lines[startLine:endLine]
-				relativeIndex := calcRelativeIndex(lines, startLine, idx)
-				reg := utils.ReadFont(regFont, vid.Opts.FontSize)
-				bold := utils.ReadFont(boldFont, vid.Opts.FontSize)
-
-				b := DrawFrame2(selectedlines, widths, relativeIndex, perc, vid.Opts, updater, reg, bold)
-				mu.Lock()
-				m[c] = b
-				mu.Unlock()
-
-			}(frameCount, iWord)
-			frameCount++
-		}
+	/* if err != nil {
+		fmt.Printf(err.Error(), "font not found")
+		return
 	}
+	*/
+	enc, err := ResolveEncoding(vid.Opts.Encoding)
+	if err != nil {
+		return fmt.Errorf("invalid encoding: %v", err)
+	}
+	vid.Opts.Encoding = enc
 
-	wg.Wait()
-
-	arr := [][]byte{}
-
-	for i := 0; i < frameCount; i++ {
-		arr = append(arr, m[i])
+	d, lineIndexMap, err := vid.newDrawer()
+	if err != nil {
+		return err
 	}
 
-	aspectRatio := fmt.Sprintf("%dx%d", vid.Opts.Width, vid.Opts.Height)
-	offset := 0.0
-	videoHeight := float64(vid.Opts.FontSize)*float64(vid.Opts.MaxLines)*vid.Opts.LineSpacing + 2*float64(vid.Opts.Padding)
+	states := Schedule(vid.Words, lineIndexMap, vid.Opts)
+	progress := newProgressTracker(vid.Progress, len(states))
+	defer progress.stop()
+
+	// stop drawing when ffmpeg fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Frames are drawn in parallel and handed to ffmpeg in order. Each frame
+	// gets a slot, and the slots channel bounds how far drawing runs ahead of
+	// the encoder.
+	slots := make(chan chan []byte, 2*runtime.NumCPU())
+	go func() {
+		defer close(slots)
+		for _, st := range states {
+			slot := make(chan []byte, 1)
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- slot:
+			}
+			go func(st FrameState) {
+				slot <- d.draw(st)
+				progress.frameDrawn()
+			}(st)
+		}
+	}()
+	frames := make(chan []byte)
+	go func() {
+		defer close(frames)
+		for slot := range slots {
+			select {
+			case <-ctx.Done():
+				return
+			case frames <- <-slot:
+			}
+		}
+	}()
 
-	switch vid.Opts.Alignment {
-	case "top":
-		offset = 0
-	case "bottom":
-		offset = float64(vid.Opts.Height) - videoHeight
-	case "center":
-		offset = float64(vid.Opts.Height)/2 - videoHeight/2
+	aspectRatio := fmt.Sprintf("%dx%d", vid.Opts.Width, vid.Opts.Height)
+	offset := utils.Iff(usesFullFrame(vid.Opts), 0, captionOffset(vid.Opts, vid.Opts.Alignment))
+
+	audio := AudioOptions{
+		HasAudio:    vid.Metadata.HasAudio,
+		Codecs:      vid.Metadata.AudioCodecs,
+		Replacement: vid.ReplacementAudioPath,
+		Music:       vid.BackgroundMusicPath,
+		MusicGain:   vid.Opts.MusicGain,
 	}
 
-	video, err := FFmpegCombineImagesToVideo(arr, vid.InputVideo, aspectRatio, vid.Opts.FPS, offset)
+	err = FFmpegCombineImagesToVideo(ctx, frames, vid.InputPath, vid.Output, aspectRatio, vid.Opts.FPS, offset, audio, enc, progress.framesEncoded)
+	if err != nil {
+		return err
+	}
 
-	vid.OutputVideo = video
+	progress.done()
 	fmt.Println("video rendered")
-	return err
+	return nil
 
 }
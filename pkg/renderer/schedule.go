@@ -0,0 +1,175 @@
+package renderer
+
+import (
+	"math"
+	"sort"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+	"github.com/elweday/go-subtitles/pkg/utils/interpolation"
+)
+
+// highlightDuration is how long, in seconds, the highlight animation of a
+// newly active word takes when styles use the normalized perc.
+const highlightDuration = 0.2
+
+// FrameState is the caption state at one output frame.
+type FrameState struct {
+	Index int     // frame number in the output
+	Time  float64 // timestamp of the frame in seconds
+
+	Blank   bool    // nothing is drawn
+	Opacity float64 // opacity of the captions, below 1 while fading out
+	Page    int     // first line of the page shown, or the word in the pop layout
+	Word    int     // active word, -1 when blank
+
+	Elapsed      float64 // seconds since the active word started
+	Progress     float64 // fraction of the active word that has been spoken
+	LineProgress float64 // fraction of the line of the active word that has been spoken
+	Perc         float64 // progress of the highlight animation
+}
+
+// Schedule maps every frame of a video lasting duration seconds to the
+// caption state at its timestamp. lineOf maps words to the line they were
+// put on by SplitIntoLines; it is nil for the pop layout. If duration is not
+// known the schedule ends with the last word.
+func Schedule(words []types.Word, lineOf map[int]int, opts types.SubtitlesOptions) []FrameState {
+	fps := opts.FPS
+	duration := opts.Duration
+	if duration <= 0 && len(words) > 0 {
+		last := words[len(words)-1]
+		duration = last.Time + last.Duration
+	}
+
+	count := int(math.Ceil(duration*fps - 1e-9))
+	states := make([]FrameState, count)
+	for i := range states {
+		states[i] = stateAt(words, lineOf, opts, i, float64(i)/fps)
+	}
+	return states
+}
+
+// lastStarted returns the index of the last word that has started at t, or
+// -1 before the first one.
+func lastStarted(words []types.Word, t float64) int {
+	// a small tolerance keeps frames that land on a word start from rounding
+	// down into the previous word
+	t += 1e-9
+	return sort.Search(len(words), func(i int) bool { return words[i].Time > t }) - 1
+}
+
+// gapAfter returns the silence between the end of word i and the start of
+// the next one. Words without a duration have no gap after them.
+func gapAfter(words []types.Word, i int) float64 {
+	if i+1 >= len(words) || words[i].Duration <= 0 {
+		return 0
+	}
+	return words[i+1].Time - (words[i].Time + words[i].Duration)
+}
+
+// IsPhraseBreak reports whether the gap after word i is long enough to hide
+// the captions and start a fresh page.
+func IsPhraseBreak(words []types.Word, i int, opts types.SubtitlesOptions) bool {
+	return opts.GapThreshold > 0 && gapAfter(words, i) >= opts.GapThreshold
+}
+
+// holdEnd returns when word i stops being shown. Words are held until the
+// next one starts unless a phrase break follows them. The last word is held
+// until it ends, or until the end of the video if it has no duration.
+func holdEnd(words []types.Word, i int, opts types.SubtitlesOptions) float64 {
+	word := words[i]
+	switch {
+	case i == len(words)-1 && word.Duration > 0:
+		return word.Time + word.Duration
+	case i == len(words)-1:
+		return math.Inf(1)
+	case IsPhraseBreak(words, i, opts):
+		return word.Time + word.Duration
+	}
+	return words[i+1].Time
+}
+
+// ActiveWord returns the index of the word shown at t, along with the
+// opacity of the captions, which is below 1 while they fade out before a
+// phrase break. It returns -1 when nothing is shown.
+func ActiveWord(words []types.Word, t float64, opts types.SubtitlesOptions) (int, float64) {
+	i := lastStarted(words, t)
+	if i < 0 {
+		return -1, 0
+	}
+
+	end := holdEnd(words, i, opts)
+	if t+1e-9 < end {
+		return i, 1
+	}
+
+	fade := opts.GapFade
+	if i+1 < len(words) {
+		fade = min(fade, words[i+1].Time-end)
+	}
+	if fade <= 0 || t >= end+fade {
+		return -1, 0
+	}
+	return i, 1 - (t-end)/fade
+}
+
+// highlightEasing shapes the progress of the highlight animation, linear
+// unless opts name an easing. Options are validated when read, so an unknown
+// name also falls back to linear.
+func highlightEasing(opts types.SubtitlesOptions) interpolation.Easing {
+	if opts.HighlightEasing == "" {
+		return interpolation.LinearEasing
+	}
+	ease, err := interpolation.LookupEasing(opts.HighlightEasing)
+	if err != nil {
+		return interpolation.LinearEasing
+	}
+	return ease
+}
+
+func stateAt(words []types.Word, lineOf map[int]int, opts types.SubtitlesOptions, index int, t float64) FrameState {
+	st := FrameState{Index: index, Time: t}
+	st.Word, st.Opacity = ActiveWord(words, t, opts)
+	if st.Word < 0 {
+		st.Blank = true
+		return st
+	}
+
+	word := words[st.Word]
+	st.Elapsed = max(t-word.Time, 0)
+	st.Perc = highlightEasing(opts)(min(st.Elapsed/highlightDuration, 1))
+
+	st.Progress = 1
+	if span := wordSpan(words, st.Word); span > 0 {
+		st.Progress = min(st.Elapsed/span, 1)
+	}
+	st.LineProgress = st.Progress
+
+	st.Page = st.Word
+	if lineOf != nil {
+		line := lineOf[st.Word]
+		st.Page = line - line%max(opts.MaxLines, 1)
+
+		first, last := st.Word, st.Word
+		for first > 0 && lineOf[first-1] == line {
+			first--
+		}
+		for last+1 < len(words) && lineOf[last+1] == line {
+			last++
+		}
+		start := words[first].Time
+		if span := words[last].Time + wordSpan(words, last) - start; span > 0 {
+			st.LineProgress = min(max(t-start, 0)/span, 1)
+		}
+	}
+	return st
+}
+
+// wordSpan returns how long word i is spoken for, up to the next word when
+// it has no duration.
+func wordSpan(words []types.Word, i int) float64 {
+	span := words[i].Duration
+	if span <= 0 && i+1 < len(words) {
+		span = words[i+1].Time - words[i].Time
+	}
+	return span
+}
@@ -0,0 +1,103 @@
+package renderer
+
+import (
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// applySpeakerStyle returns opts with the colours configured for speaker.
+func applySpeakerStyle(opts types.SubtitlesOptions, speaker string) types.SubtitlesOptions {
+	style, ok := opts.SpeakerStyles[speaker]
+	if !ok {
+		return opts
+	}
+	if style.FontColor != "" {
+		opts.FontColor = style.FontColor
+	}
+	if style.FontSelectedColor != "" {
+		opts.FontSelectedColor = style.FontSelectedColor
+	}
+	if style.HighlightColor != "" {
+		opts.HighlightColor = style.HighlightColor
+	}
+	return opts
+}
+
+// pageSpeaker returns the speaker of the first word on the page.
+func pageSpeaker(lines [][]types.Word) string {
+	for _, line := range lines {
+		if len(line) > 0 {
+			return line[0].Speaker
+		}
+	}
+	return ""
+}
+
+// usesFullFrame reports whether any speaker has its own vertical alignment,
+// in which case the overlay covers the whole video and captions are placed
+// inside it per page instead of through the ffmpeg overlay offset.
+func usesFullFrame(opts types.SubtitlesOptions) bool {
+	for _, style := range opts.SpeakerStyles {
+		if style.Alignment != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// frameHeight returns the height of the images fed to ffmpeg.
+func frameHeight(opts types.SubtitlesOptions) float64 {
+	if usesFullFrame(opts) {
+		return float64(opts.Height)
+	}
+	return overlayHeight(opts)
+}
+
+// captionOffset returns the distance from the top of the video to the
+// caption block for the given alignment.
+func captionOffset(opts types.SubtitlesOptions, alignment string) float64 {
+	switch alignment {
+	case "bottom":
+		return float64(opts.Height) - overlayHeight(opts)
+	case "center":
+		return float64(opts.Height)/2 - overlayHeight(opts)/2
+	}
+	return 0
+}
+
+// blockTop returns where the caption block starts inside a frame drawn for
+// the given speaker.
+func blockTop(opts types.SubtitlesOptions, speaker string) float64 {
+	if !usesFullFrame(opts) {
+		return 0
+	}
+	alignment := opts.Alignment
+	if style, ok := opts.SpeakerStyles[speaker]; ok && style.Alignment != "" {
+		alignment = style.Alignment
+	}
+	return captionOffset(opts, alignment)
+}
+
+// lineStartOffset returns how far from the start edge a line of the given
+// width begins, following the speaker's anchor or opts.Center. The width
+// counts the padding and the space after the last word, which is left out so
+// that anchored lines are inset by the padding from either edge.
+func lineStartOffset(opts types.SubtitlesOptions, speaker string, width, spaceWidth float64) float64 {
+	anchor := opts.SpeakerStyles[speaker].Anchor
+	if opts.RTL {
+		switch anchor {
+		case "left":
+			anchor = "right"
+		case "right":
+			anchor = "left"
+		}
+	}
+	switch {
+	case anchor == "left":
+		return 0
+	case anchor == "right":
+		return float64(opts.Width) - float64(opts.Padding) - width + spaceWidth
+	case anchor == "center" || opts.Center:
+		return (float64(opts.Width) - width) / 2
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+// Package safeurl keeps URLs sent by clients from reaching the network of
+// the server: loopback, private, link-local and other non-public addresses
+// are refused unless their host is allowed explicitly.
+package safeurl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AllowedHosts, when not empty, are the only hosts URLs may point to. An
+// entry matches the host and its subdomains. Allowed hosts may resolve to
+// any address, which is how internal services are reached on purpose.
+var AllowedHosts []string
+
+// ParseHosts reads a comma separated list of hosts, as kept in AllowedHosts.
+func ParseHosts(s string) []string {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, strings.TrimSuffix(h, "."))
+		}
+	}
+	return hosts
+}
+
+// lookupTimeout bounds the DNS lookup of Check.
+const lookupTimeout = 5 * time.Second
+
+// cgnat is the shared address space of carrier-grade NAT, RFC 6598.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// Blocked reports whether addr is not a public unicast address.
+func Blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsValid() ||
+		addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified() ||
+		(addr.Is4() && (addr.As4()[0] == 0 || addr.As4() == [4]byte{255, 255, 255, 255})) ||
+		cgnat.Contains(addr)
+}
+
+// allowed reports whether host is in AllowedHosts.
+func allowed(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, h := range AllowedHosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error unless rawURL is an http or https URL whose host
+// is allowed. Without AllowedHosts, every address the host resolves to must
+// be public.
+//
+// Whoever fetches the URL resolves the host again, so a host can change its
+// address in between, and the URL may redirect elsewhere. Fetch it with
+// Client, which checks the address that is actually connected to, rather
+// than handing it to another program.
+func Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("expected an http or https URL")
+	}
+	host := u.Hostname()
+	if len(AllowedHosts) > 0 {
+		if !allowed(host) {
+			return fmt.Errorf("host %s is not allowed", host)
+		}
+		return nil
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if Blocked(addr) {
+			return fmt.Errorf("address %s is not allowed", addr)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if Blocked(addr) {
+			return fmt.Errorf("host %s resolves to %s, which is not allowed", host, addr.Unmap())
+		}
+	}
+	return nil
+}
+
+// control refuses connections to blocked addresses. It runs after the host
+// is resolved, so a host that changes its address can't get past it.
+func control(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("cannot parse address %s: %v", address, err)
+	}
+	if Blocked(addrPort.Addr()) {
+		return fmt.Errorf("address %s is not allowed", addrPort.Addr().Unmap())
+	}
+	return nil
+}
+
+var (
+	dialer        = &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	guardedDialer = &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: control}
+)
+
+// DialContext connects to addr like net.Dialer.DialContext. Without
+// AllowedHosts it refuses blocked addresses; with them it refuses other
+// hosts and dials allowed ones as is.
+func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(AllowedHosts) == 0 {
+		return guardedDialer.DialContext(ctx, network, addr)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed(host) {
+		return nil, fmt.Errorf("host %s is not allowed", host)
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Client returns an HTTP client that only connects to public addresses and
+// checks every redirect with Check. It ignores proxy settings, as a proxy
+// would dial on its behalf.
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return Check(req.Context(), req.URL.String())
+		},
+	}
+}
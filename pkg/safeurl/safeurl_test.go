@@ -0,0 +1,237 @@
+package safeurl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withHosts sets AllowedHosts for the length of a test.
+func withHosts(t *testing.T, hosts ...string) {
+	saved := AllowedHosts
+	AllowedHosts = hosts
+	t.Cleanup(func() { AllowedHosts = saved })
+}
+
+func TestBlocked(t *testing.T) {
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		// loopback
+		{"127.0.0.1", true},
+		{"127.255.0.9", true},
+		{"::1", true},
+		// link-local, metadata servers included
+		{"169.254.169.254", true},
+		{"169.254.0.1", true},
+		{"fe80::1", true},
+		// RFC 1918
+		{"10.0.0.1", true},
+		{"10.255.255.255", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		// IPv6 unique local
+		{"fc00::1", true},
+		{"fd12:3456:789a::1", true},
+		// IPv4-mapped IPv6
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.1.2.3", true},
+		{"::ffff:169.254.169.254", true},
+		{"::ffff:8.8.8.8", false},
+		// carrier-grade NAT, multicast, unspecified and broadcast
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"::", true},
+		{"255.255.255.255", true},
+		// public
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"100.128.0.1", false},
+		{"93.184.216.34", false},
+		{"2606:4700:4700::1111", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := Blocked(netip.MustParseAddr(tt.addr)); got != tt.blocked {
+				t.Errorf("Blocked(%s) = %t, want %t", tt.addr, got, tt.blocked)
+			}
+		})
+	}
+	if !Blocked(netip.Addr{}) {
+		t.Error("the zero address is not blocked")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	withHosts(t)
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{"https://8.8.8.8/video.mp4", ""},
+		{"http://[2606:4700:4700::1111]:8080/v", ""},
+		{"http://127.0.0.1/", "not allowed"},
+		{"http://169.254.169.254/computeMetadata/v1/", "not allowed"},
+		{"http://10.0.0.1:9000/v", "not allowed"},
+		{"http://[::1]/", "not allowed"},
+		{"http://[fd00::1]/", "not allowed"},
+		{"http://[::ffff:169.254.169.254]/", "not allowed"},
+		{"http://localhost:8080/", "not allowed"},
+		{"ftp://8.8.8.8/v", "expected an http or https URL"},
+		{"file:///etc/passwd", "expected an http or https URL"},
+		{"https:///path", "expected an http or https URL"},
+		{"not a url", "expected an http or https URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := Check(context.Background(), tt.url)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Check() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Check() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCancelled(t *testing.T) {
+	withHosts(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Check(ctx, "https://example.com/"); err == nil {
+		t.Error("Check() with a cancelled context succeeded")
+	}
+}
+
+func TestAllowedHosts(t *testing.T) {
+	if got, want := ParseHosts(" Videos.Example.com., ,cdn.example.org "), []string{"videos.example.com", "cdn.example.org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseHosts() = %q, want %q", got, want)
+	}
+
+	withHosts(t, "example.com", "10.0.0.5")
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/v", true},
+		{"https://EXAMPLE.com./v", true},
+		{"https://cdn.example.com/v", true},
+		{"https://a.b.example.com/v", true},
+		// allowed hosts may point anywhere, internal services included
+		{"http://10.0.0.5:9000/v", true},
+		{"https://badexample.com/v", false},
+		{"https://example.com.evil.net/v", false},
+		{"https://example.org/v", false},
+		{"http://10.0.0.6/v", false},
+		{"https://8.8.8.8/v", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if err := Check(context.Background(), tt.url); (err == nil) != tt.allowed {
+				t.Errorf("Check() = %v, want allowed %t", err, tt.allowed)
+			}
+		})
+	}
+
+	if _, err := DialContext(context.Background(), "tcp", "example.org:443"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("DialContext() to a host that isn't allowed = %v", err)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	withHosts(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// the address is checked after the host is resolved, so a name that
+	// resolves to loopback is refused as well
+	for _, addr := range []string{ln.Addr().String(), "localhost:" + port} {
+		conn, err := DialContext(context.Background(), "tcp", addr)
+		if err == nil {
+			conn.Close()
+			t.Errorf("DialContext(%s) connected", addr)
+		} else if !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("DialContext(%s) = %v, want a refused address", addr, err)
+		}
+	}
+}
+
+func TestClientRefusesPrivateRedirect(t *testing.T) {
+	withHosts(t)
+	var reached atomic.Bool
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached.Store(true)
+	}))
+	defer private.Close()
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	}))
+	defer public.Close()
+
+	// stand the first server in for a public host, every other connection
+	// goes through the guarded dialer
+	client := Client(5 * time.Second)
+	transport := client.Transport.(*http.Transport)
+	publicAddr := public.Listener.Addr().String()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == publicAddr {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+		return DialContext(ctx, network, addr)
+	}
+	_, privatePort, _ := net.SplitHostPort(private.Listener.Addr().String())
+
+	for _, target := range []string{
+		private.URL,
+		"http://localhost:" + privatePort + "/",
+		"http://169.254.169.254/computeMetadata/v1/",
+	} {
+		t.Run(target, func(t *testing.T) {
+			res, err := client.Get(public.URL + "/?to=" + target)
+			if err == nil {
+				res.Body.Close()
+				t.Fatalf("redirect to %s was followed with status %s", target, res.Status)
+			}
+			if !strings.Contains(err.Error(), "not allowed") {
+				t.Errorf("Get() = %v, want a refused address", err)
+			}
+		})
+	}
+	if reached.Load() {
+		t.Error("the private server was reached")
+	}
+
+	// the dialer alone refuses the private server, as when a host changes
+	// its address after CheckRedirect resolved it
+	transport.DialContext = DialContext
+	client.CheckRedirect = nil
+	if res, err := client.Get(private.URL); err == nil {
+		res.Body.Close()
+		t.Error("the private server was fetched")
+	}
+	if reached.Load() {
+		t.Error("the private server was reached")
+	}
+}
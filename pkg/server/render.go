@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/renderer"
+)
+
+// RenderAPI serves render requests that answer with the video.
+type RenderAPI struct {
+	Timeout time.Duration // longest a render may take
+	Limits  handlers.UploadLimits
+}
+
+// decodeRequest reads the JSON body of a render request, answering with 400
+// and returning false if it is invalid.
+func (a *RenderAPI) decodeRequest(w http.ResponseWriter, r *http.Request) (*handlers.EndPointHandler, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, a.Limits.Total())
+	handler := &handlers.EndPointHandler{}
+
+	if err := json.NewDecoder(r.Body).Decode(handler); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprintf(w, "request body is larger than %d bytes", maxBytes.Limit)
+			return nil, false
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "error parsing request body: "+err.Error())
+		return nil, false
+	}
+	if err := handler.Validate(r.Context()); err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "invalid request body: "+err.Error())
+		return nil, false
+	}
+	return handler, true
+}
+
+// Render takes a JSON render request and streams back the video.
+func (a *RenderAPI) Render(w http.ResponseWriter, r *http.Request) {
+	handler, ok := a.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// the request context is cancelled when the client disconnects
+	ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+	defer cancel()
+
+	renderResponse(ctx, w, handler)
+}
+
+// Upload takes a multipart/form-data body with "video", "transcript" and
+// optional "config" parts, and streams back the rendered video as an
+// attachment.
+func (a *RenderAPI) Upload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, a.Limits.Total())
+
+	handler, err := handlers.ReadMultipart(r, a.Limits)
+	if err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "invalid upload: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+	defer cancel()
+
+	renderResponse(ctx, w, handler)
+}
+
+// Preview takes the body of Render and answers with a PNG of the captions
+// over the video frame at the "t" query parameter, or with a contact sheet
+// of several frames, see handlers.ParsePreviewQuery.
+func (a *RenderAPI) Preview(w http.ResponseWriter, r *http.Request) {
+	req, err := handlers.ParsePreviewQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "invalid preview: "+err.Error())
+		return
+	}
+	handler, ok := a.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+	defer cancel()
+
+	previewResponse(ctx, w, handler, req)
+}
+
+// PreviewUpload takes the multipart body of Upload and the query parameters
+// of Preview.
+func (a *RenderAPI) PreviewUpload(w http.ResponseWriter, r *http.Request) {
+	req, err := handlers.ParsePreviewQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "invalid preview: "+err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, a.Limits.Total())
+
+	handler, err := handlers.ReadMultipart(r, a.Limits)
+	if err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "invalid upload: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+	defer cancel()
+
+	previewResponse(ctx, w, handler, req)
+}
+
+// previewResponse draws the preview picked by req and writes it as a PNG.
+func previewResponse(ctx context.Context, w http.ResponseWriter, handler *handlers.EndPointHandler, req handlers.PreviewRequest) {
+	img, err := handlers.Preview(ctx, handler, req)
+	if err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "couldn't draw preview: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+		"filename": handlers.PreviewFilename(handler.VideoName),
+	}))
+	w.Write(img)
+}
+
+// renderResponse renders the request read by handler and streams the video
+// to the response as ffmpeg encodes it.
+func renderResponse(ctx context.Context, w http.ResponseWriter, handler *handlers.EndPointHandler) {
+	defer handler.Close()
+	vid, err := handler.Read(ctx)
+	if err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "couldn't read body: "+err.Error())
+		return
+
+	}
+
+	out := &responseWriter{ResponseWriter: w}
+	vid.Output = out
+	w.Header().Set("Content-Type", vid.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": handlers.OutputFilename(handler.VideoName, vid.FileExtension()),
+	}))
+	err = vid.RenderWithSubtitles(ctx)
+	if err != nil {
+		if out.written {
+			// the status and part of the video are already sent, so the
+			// client only sees a truncated response
+			log.Printf("render failed after streaming started: %v\n", err)
+			return
+		}
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "couldn't render subtitles for video: "+err.Error())
+		return
+	}
+}
+
+// responseWriter records whether the response body has been started.
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Firestore takes a JSON body naming a Firestore document and a bucket, with
+// the service account credentials to reach them, see handlers.GcpIOHandler,
+// and renders the request stored in the document.
+// Progress is written to the status field of the document, and the answer
+// names the rendered object once it is uploaded.
+func (a *RenderAPI) Firestore(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, a.Limits.Config+gcpRequestBytes)
+	handler := &handlers.GcpIOHandler{}
+	if err := json.NewDecoder(r.Body).Decode(handler); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "error parsing request body: "+err.Error())
+		return
+	}
+	if err := handler.Validate(); err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "invalid request body: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+	defer cancel()
+
+	if err := handlers.Render(ctx, handler); err != nil {
+		w.WriteHeader(handlers.ErrorStatus(err))
+		fmt.Fprint(w, "couldn't render subtitles for video: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Doc    string `json:"doc"`
+		Output string `json:"output"`
+	}{handler.Doc, "gs://" + handler.BucketName + "/" + handler.OutputObject})
+}
+
+// gcpRequestBytes leaves room for the names and credentials of a Firestore
+// request.
+const gcpRequestBytes = 64 << 10
+
+// Events takes the same body as Render and streams server-sent events:
+// "progress" while rendering, "warnings" with the line-breaking rules the
+// captions break, if any, then "result" with the base64 encoded video, or
+// "error". The video is buffered in memory, so large renders should use
+// Render.
+func (a *RenderAPI) Events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "streaming is not supported")
+		return
+	}
+	handler, ok := a.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(event string, data any) {
+		b, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+		flusher.Flush()
+	}
+	sendError := func(msg string) {
+		send("error", map[string]string{"error": msg})
+	}
+
+	defer handler.Close()
+	vid, err := handler.Read(ctx)
+	if err != nil {
+		sendError("couldn't read body: " + err.Error())
+		return
+	}
+	video := &bytes.Buffer{}
+	vid.Output = video
+	// progress is reported from the render goroutines one call at a time,
+	// and nothing else writes to w until the render returns
+	vid.Progress = func(p renderer.Progress) { send("progress", p) }
+	err = vid.RenderWithSubtitles(ctx)
+	if len(vid.Warnings) > 0 {
+		send("warnings", vid.Warnings)
+	}
+	if err != nil {
+		sendError("couldn't render subtitles for video: " + err.Error())
+		return
+	}
+
+	send("result", struct {
+		ContentType string `json:"contentType"`
+		Video       []byte `json:"video"`
+	}{vid.ContentType(), video.Bytes()})
+}
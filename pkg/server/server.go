@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elweday/go-subtitles/pkg/handlers"
+	"github.com/elweday/go-subtitles/pkg/jobs"
+	"github.com/elweday/go-subtitles/pkg/renderer"
+)
+
+// Config configures a Server.
+type Config struct {
+	Addr         string
+	ReadTimeout  time.Duration // reading a whole request, uploads included
+	WriteTimeout time.Duration // writing a whole response, streamed renders included
+	IdleTimeout  time.Duration
+	// DrainDelay is how long Shutdown keeps serving after /readyz starts
+	// failing, so load balancers stop sending requests first.
+	DrainDelay time.Duration
+	// ShutdownTimeout is how long Shutdown lets running renders finish
+	// before cancelling them.
+	ShutdownTimeout time.Duration
+	RenderTimeout   time.Duration
+	Limits          handlers.UploadLimits
+	// Jobs configures the job API. It is disabled when Jobs.Dir is empty.
+	Jobs jobs.Options
+	// Firestore serves /render/firestore. Requests carry their own
+	// credentials, but the route is off unless asked for.
+	Firestore bool
+}
+
+var DefaultConfig = Config{
+	Addr:            ":8080",
+	ReadTimeout:     10 * time.Minute,
+	WriteTimeout:    35 * time.Minute,
+	IdleTimeout:     2 * time.Minute,
+	DrainDelay:      5 * time.Second,
+	ShutdownTimeout: 30 * time.Minute,
+	RenderTimeout:   30 * time.Minute,
+	Limits:          handlers.DefaultUploadLimits,
+}
+
+// Server serves the render and job APIs:
+//
+//	POST /render           JSON request, answers with the video
+//	POST /render/upload    multipart request, answers with the video
+//	POST /render/events    JSON request, answers with server-sent events
+//	POST /render/firestore renders a request stored in Firestore, when enabled
+//	POST /preview          JSON request, answers with a PNG of the captions
+//	POST /preview/upload   multipart request, answers with a PNG
+//	     /jobs/...         asynchronous jobs, see jobs.Manager.ServeHTTP
+//	GET  /healthz          ffmpeg and ffprobe are installed
+//	GET  /readyz           as /healthz, and the server is not shutting down
+type Server struct {
+	cfg      Config
+	http     *http.Server
+	jobs     *jobs.Manager
+	draining atomic.Bool
+	renders  sync.WaitGroup // synchronous renders in progress
+}
+
+// New creates a Server, opening the job store when the job API is enabled.
+func New(cfg Config) (*Server, error) {
+	s := &Server{cfg: cfg}
+	if cfg.Jobs.Dir != "" {
+		if cfg.Jobs.Limits == (handlers.UploadLimits{}) {
+			cfg.Jobs.Limits = cfg.Limits
+		}
+		m, err := jobs.NewManager(cfg.Jobs)
+		if err != nil {
+			return nil, err
+		}
+		s.jobs = m
+	}
+
+	s.http = &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      s.Handler(),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	return s, nil
+}
+
+// Handler returns the routes of the server.
+func (s *Server) Handler() http.Handler {
+	api := &RenderAPI{Timeout: s.cfg.RenderTimeout, Limits: s.cfg.Limits}
+
+	mux := http.NewServeMux()
+	mux.Handle("/render", s.render(post(api.Render)))
+	mux.Handle("/render/upload", s.render(post(api.Upload)))
+	mux.Handle("/render/events", s.render(post(api.Events)))
+	mux.Handle("/preview", s.render(post(api.Preview)))
+	mux.Handle("/preview/upload", s.render(post(api.PreviewUpload)))
+	if s.cfg.Firestore {
+		mux.Handle("/render/firestore", s.render(post(api.Firestore)))
+	}
+	if s.jobs != nil {
+		mux.Handle("/jobs", s.jobs)
+		mux.Handle("/jobs/", s.jobs)
+	}
+	mux.HandleFunc("/healthz", get(s.healthz))
+	mux.HandleFunc("/readyz", get(s.readyz))
+	return mux
+}
+
+// render counts the renders in progress so Shutdown can wait for them, and
+// turns new ones away once it has started.
+func (s *Server) render(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		s.renders.Add(1)
+		defer s.renders.Done()
+		next(w, r)
+	})
+}
+
+func post(next http.HandlerFunc) http.HandlerFunc {
+	return method(http.MethodPost, next)
+}
+
+func get(next http.HandlerFunc) http.HandlerFunc {
+	return method(http.MethodGet, next)
+}
+
+func method(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != name && !(name == http.MethodGet && r.Method == http.MethodHead) {
+			w.Header().Set("Allow", name)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if err := renderer.CheckTools(); err != nil {
+		writeCheck(w, http.StatusServiceUnavailable, check{"unhealthy", err.Error()})
+		return
+	}
+	writeCheck(w, http.StatusOK, check{Status: "ok"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeCheck(w, http.StatusServiceUnavailable, check{"shutting down", ""})
+		return
+	}
+	s.healthz(w, r)
+}
+
+func writeCheck(w http.ResponseWriter, status int, c check) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
+
+// ListenAndServe serves until Shutdown is called, when it returns nil.
+func (s *Server) ListenAndServe() error {
+	log.Printf("listening on %s\n", s.cfg.Addr)
+	if err := s.http.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown fails /readyz, keeps serving for DrainDelay, then stops accepting
+// requests and waits up to ShutdownTimeout for running renders and jobs to
+// finish, cancelling them after that. Jobs cancelled this way are queued
+// again on the next start.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	select {
+	case <-time.After(s.cfg.DrainDelay):
+	case <-ctx.Done():
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	go func() {
+		// waits for open requests, the synchronous renders among them
+		errs <- s.http.Shutdown(ctx)
+	}()
+	go func() {
+		if s.jobs == nil {
+			errs <- nil
+			return
+		}
+		errs <- s.jobs.Shutdown(ctx)
+	}()
+	err := errors.Join(<-errs, <-errs)
+
+	if ctx.Err() != nil {
+		// cancels the contexts of the renders still running
+		s.http.Close()
+		s.renders.Wait()
+	}
+	return err
+}
@@ -7,8 +7,15 @@ import (
 
 type ScrollingBox types.SubtitlesOptions
 
-var f = interpolation.Spring(0.9, 1, types.SpringOptions{Stiffness: 3, Damping: 0.1, Mass: 0.5})
+var f = interpolation.Spring(0.9, 1, types.SpringOptions{Stiffness: 300, Damping: 12, Mass: 1})
 
+var grow = interpolation.Linear(0.9, 1)
+
+// Update springs the highlight in, or follows perc when an easing shapes it.
 func (ScrollingBox) Update(opts *types.SubtitlesOptions, perc float64) {
-	opts.HighlightScale = f(perc)
+	if opts.HighlightEasing != "" {
+		opts.HighlightScale = grow(perc)
+		return
+	}
+	opts.HighlightScale = f(opts.WordElapsed)
 }
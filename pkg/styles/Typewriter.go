@@ -0,0 +1,24 @@
+package styles
+
+import (
+	"math"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+type Typewriter types.SubtitlesOptions
+
+// Update reveals the active word, or its whole line with the "line" scope,
+// over its spoken duration rather than over the short highlight animation,
+// so it reads opts.WordProgress or opts.LineProgress and ignores perc.
+func (Typewriter) Update(opts *types.SubtitlesOptions, perc float64) {
+	opts.Reveal = true
+	opts.RevealProgress = opts.WordProgress
+	if opts.TypewriterScope == "line" {
+		opts.RevealProgress = opts.LineProgress
+	}
+	opts.CursorVisible = opts.TypewriterCursor != ""
+	if opts.CursorVisible && opts.CursorBlinkRate > 0 {
+		opts.CursorVisible = math.Mod(opts.Time*opts.CursorBlinkRate, 1) < 0.5
+	}
+}
@@ -0,0 +1,18 @@
+package styles
+
+import "github.com/elweday/go-subtitles/pkg/types"
+
+// Registry maps the names accepted in SubtitlesOptions.Style to updaters.
+var Registry = map[string]types.Updater{
+	"scrollingBox": ScrollingBox{},
+	"typewriter":   Typewriter{},
+}
+
+// Get returns the updater registered under name, falling back to
+// ScrollingBox when the name is empty or unknown.
+func Get(name string) types.Updater {
+	if u, ok := Registry[name]; ok {
+		return u
+	}
+	return ScrollingBox{}
+}
@@ -0,0 +1,357 @@
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/elweday/go-subtitles/pkg/renderer"
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// Defaults for the cue options of SubtitlesOptions left at zero.
+const (
+	defaultCharsPerLine   = 42
+	defaultMaxCueDuration = 7.0 // seconds
+	minCueDuration        = 0.5 // seconds, for cues of words without durations
+)
+
+// Cue is a caption shown from Start to End, in seconds.
+type Cue struct {
+	Start   float64
+	End     float64
+	Text    string // lines joined with "\n"
+	Speaker string
+
+	emphasis []bool // of each word of Text, from bold, italic or underline markup
+}
+
+// Cues groups words into caption cues of at most MaxLines lines of
+// MaxCharsPerLine characters, lasting at most MaxCueDuration. A cue also
+// ends at a phrase break and where the speaker changes.
+func Cues(words []types.Word, opts types.SubtitlesOptions) []Cue {
+	lineChars := opts.MaxCharsPerLine
+	if lineChars <= 0 {
+		lineChars = defaultCharsPerLine
+	}
+	maxLines := max(opts.MaxLines, 1)
+	maxDuration := opts.MaxCueDuration
+	if maxDuration <= 0 {
+		maxDuration = defaultMaxCueDuration
+	}
+
+	var cues []Cue
+	start := 0
+	for i := range words {
+		last := i == len(words)-1
+		if !last {
+			next := words[i+1]
+			text := joinWords(words[start : i+2])
+			split := renderer.IsPhraseBreak(words, i, opts) ||
+				next.Speaker != words[start].Speaker ||
+				len(wrap(text, lineChars)) > maxLines ||
+				wordEnd(words, i+1)-words[start].Time > maxDuration
+			if !split {
+				continue
+			}
+		}
+		cues = append(cues, Cue{
+			Start:    words[start].Time,
+			End:      wordEnd(words, i),
+			Text:     strings.Join(wrap(joinWords(words[start:i+1]), lineChars), "\n"),
+			Speaker:  words[start].Speaker,
+			emphasis: emphasisOf(words[start : i+1]),
+		})
+		start = i + 1
+	}
+
+	// words without durations end when the next cue starts
+	for i := range cues {
+		if i+1 < len(cues) && cues[i].End > cues[i+1].Start {
+			cues[i].End = cues[i+1].Start
+		}
+	}
+	return cues
+}
+
+// wordEnd returns when word i ends, or when the next one starts if it has
+// no duration.
+func wordEnd(words []types.Word, i int) float64 {
+	if d := words[i].Duration; d > 0 {
+		return words[i].Time + d
+	}
+	if i+1 < len(words) {
+		return words[i+1].Time
+	}
+	return words[i].Time + minCueDuration
+}
+
+func joinWords(words []types.Word) string {
+	values := make([]string, len(words))
+	for i, w := range words {
+		values[i] = w.Value
+	}
+	return strings.Join(values, " ")
+}
+
+// emphasisOf returns which words are emphasised, or nil when none are.
+func emphasisOf(words []types.Word) []bool {
+	var emphasis []bool
+	for i, w := range words {
+		if w.Emphasis && emphasis == nil {
+			emphasis = make([]bool, len(words))
+		}
+		if emphasis != nil {
+			emphasis[i] = w.Emphasis
+		}
+	}
+	return emphasis
+}
+
+// markedText returns the text of c with its emphasised words in bold
+// markup.
+func (c Cue) markedText() string {
+	if c.emphasis == nil {
+		return c.Text
+	}
+	lines := strings.Split(c.Text, "\n")
+	i := 0
+	for l, line := range lines {
+		fields := strings.Fields(line)
+		for j, f := range fields {
+			if i < len(c.emphasis) && c.emphasis[i] {
+				fields[j] = "<b>" + f + "</b>"
+			}
+			i++
+		}
+		lines[l] = strings.Join(fields, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wrap breaks text into lines of at most width characters, keeping words
+// longer than width whole.
+func wrap(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, w := range strings.Fields(text) {
+		if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(w) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += w
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// words splits the text of c into words that share its time in proportion
+// to their length.
+func (c Cue) words() []types.Word {
+	values := strings.Fields(c.Text)
+	total := 0
+	for _, v := range values {
+		total += utf8.RuneCountInString(v) + 1
+	}
+	words := make([]types.Word, len(values))
+	chars := 0
+	start := round(c.Start)
+	for i, v := range values {
+		chars += utf8.RuneCountInString(v) + 1
+		// rounding the boundaries keeps the words end to end
+		end := round(c.Start + (c.End-c.Start)*float64(chars)/float64(total))
+		words[i] = types.Word{Time: start, Duration: round(end - start), Value: v, Speaker: c.Speaker}
+		if i < len(c.emphasis) {
+			words[i].Emphasis = c.emphasis[i]
+		}
+		start = end
+	}
+	return words
+}
+
+func round(t float64) float64 {
+	return math.Round(t*1000) / 1000
+}
+
+var (
+	voiceTag = regexp.MustCompile(`<v(?:\.[^ >]*)?\s+([^>]*)>`)
+	markup   = regexp.MustCompile(`<[^>]*>|\{\\[^}]*\}`)
+	// <b>, <i> and <u>, and the {\b1} and {\i1} overrides of SSA in SRT
+	emphasisOpen  = regexp.MustCompile(`^(<[biu](\.[^>]*)?>|\{\\[biu]1\})$`)
+	emphasisClose = regexp.MustCompile(`^(</[biu]>|\{\\[biu]0\})$`)
+)
+
+// stripMarkup removes the markup of a cue text, returning the plain text
+// and which of its words were inside bold, italic or underline markup. A
+// word is emphasised if any part of it is.
+func stripMarkup(text string) (string, []bool) {
+	var plain strings.Builder
+	var marked []bool // of each byte of plain
+	depth := 0
+	write := func(s string) {
+		plain.WriteString(s)
+		for i := 0; i < len(s); i++ {
+			marked = append(marked, depth > 0)
+		}
+	}
+	last := 0
+	for _, loc := range markup.FindAllStringIndex(text, -1) {
+		write(text[last:loc[0]])
+		switch tag := text[loc[0]:loc[1]]; {
+		case emphasisOpen.MatchString(tag):
+			depth++
+		case emphasisClose.MatchString(tag) && depth > 0:
+			depth--
+		}
+		last = loc[1]
+	}
+	write(text[last:])
+
+	s := plain.String()
+	var emphasis []bool
+	inWord, emphasised := false, false
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if !space {
+			emphasised = emphasised || marked[i]
+		}
+		if space && inWord {
+			emphasis = append(emphasis, emphasised)
+			emphasised = false
+		}
+		inWord = !space
+	}
+	if inWord {
+		emphasis = append(emphasis, emphasised)
+	}
+	return s, emphasis
+}
+
+// parseCues reads the cues of an SRT or WebVTT file. Cue numbers, settings,
+// NOTE and STYLE blocks and other markup are ignored; WebVTT voice tags set
+// the speaker, and bold, italic and underline text is emphasised.
+func parseCues(data []byte, f Format) ([]Cue, error) {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+
+	var cues []Cue
+	var block []string
+	lineNo := 0
+	flush := func() error {
+		defer func() { block = nil }()
+		// the timing line is the first or, after a cue id, the second
+		timing := -1
+		for i, l := range block {
+			if strings.Contains(l, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 || timing > 1 {
+			if len(block) > 0 && f == SRT {
+				return fmt.Errorf("line %d: expected a cue timing", lineNo-len(block))
+			}
+			// WEBVTT header, NOTE, STYLE and REGION blocks
+			return nil
+		}
+		c, err := parseTiming(block[timing])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNo-len(block)+timing, err)
+		}
+		text := strings.Join(block[timing+1:], "\n")
+		if m := voiceTag.FindStringSubmatch(text); m != nil {
+			c.Speaker = strings.TrimSpace(m[1])
+		}
+		c.Text, c.emphasis = stripMarkup(text)
+		c.Text = strings.TrimSpace(c.Text)
+		if c.Text != "" {
+			cues = append(cues, c)
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	lineNo++
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if f == VTT && !bytes.HasPrefix(data, []byte("WEBVTT")) {
+		return nil, fmt.Errorf("missing WEBVTT header")
+	}
+	return cues, nil
+}
+
+// parseTiming reads "start --> end" followed by optional cue settings.
+func parseTiming(line string) (Cue, error) {
+	start, rest, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return Cue{}, fmt.Errorf("missing cue end in %q", line)
+	}
+	s, err := parseTimestamp(strings.TrimSpace(start))
+	if err != nil {
+		return Cue{}, err
+	}
+	e, err := parseTimestamp(fields[0])
+	if err != nil {
+		return Cue{}, err
+	}
+	if e < s {
+		return Cue{}, fmt.Errorf("cue ends before it starts in %q", line)
+	}
+	return Cue{Start: s, End: e}, nil
+}
+
+// parseTimestamp reads [hh:]mm:ss.mmm, with a comma or a dot before the
+// milliseconds.
+func parseTimestamp(s string) (float64, error) {
+	parts := strings.Split(strings.Replace(s, ",", ".", 1), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var t float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || (i < len(parts)-1 && strings.Contains(p, ".")) {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		t = t*60 + v
+	}
+	return t, nil
+}
+
+// timestamp formats t seconds as hh:mm:ss,mmm for SRT or hh:mm:ss.mmm for
+// WebVTT.
+func timestamp(t float64, f Format) string {
+	ms := int64(math.Round(max(t, 0) * 1000))
+	sep := "."
+	if f == SRT {
+		sep = ","
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
@@ -0,0 +1,330 @@
+package transcript
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"00:01:02,500", 62.5, false},
+		{"00:01:02.500", 62.5, false},
+		{"01:02:03.004", 3723.004, false},
+		{"01:02.250", 62.25, false},
+		{"00:00:00,000", 0, false},
+		{"100:00:00.000", 360000, false},
+		{"12", 0, true},
+		{"1:2:3:4", 0, true},
+		{"aa:00:00,000", 0, true},
+		{"00:1.5:00", 0, true},
+		{"-1:00:00,000", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTimestamp(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimestamp() error = %v, want error %t", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("parseTimestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		t        float64
+		srt, vtt string
+	}{
+		{0, "00:00:00,000", "00:00:00.000"},
+		{62.5, "00:01:02,500", "00:01:02.500"},
+		{3723.004, "01:02:03,004", "01:02:03.004"},
+		{1.9996, "00:00:02,000", "00:00:02.000"},
+		{-1, "00:00:00,000", "00:00:00.000"},
+	}
+	for _, tt := range tests {
+		if got := timestamp(tt.t, SRT); got != tt.srt {
+			t.Errorf("timestamp(%v, SRT) = %q, want %q", tt.t, got, tt.srt)
+		}
+		if got := timestamp(tt.t, VTT); got != tt.vtt {
+			t.Errorf("timestamp(%v, VTT) = %q, want %q", tt.t, got, tt.vtt)
+		}
+	}
+}
+
+func TestParseCues(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		in      string
+		want    []Cue
+		wantErr string
+	}{
+		{
+			name:   "srt with cue ids and comma timestamps",
+			format: SRT,
+			in:     "1\n00:00:01,000 --> 00:00:02,500\nHello there\n\n2\n00:00:03,000 --> 00:00:04,000\nSecond line\nwraps here\n",
+			want: []Cue{
+				{Start: 1, End: 2.5, Text: "Hello there", emphasis: []bool{false, false}},
+				{Start: 3, End: 4, Text: "Second line\nwraps here", emphasis: []bool{false, false, false, false}},
+			},
+		},
+		{
+			name:   "srt with a byte order mark, CRLF and dot timestamps",
+			format: SRT,
+			in:     "\uFEFF1\r\n00:00:01.000 --> 00:00:02.000\r\nHi\r\n\r\n",
+			want:   []Cue{{Start: 1, End: 2, Text: "Hi", emphasis: []bool{false}}},
+		},
+		{
+			name:   "srt emphasis markup",
+			format: SRT,
+			in:     "1\n00:00:00,000 --> 00:00:02,000\n<b>Very</b> <i>im</i>portant {\\b1}stuff{\\b0} here\n",
+			want:   []Cue{{Start: 0, End: 2, Text: "Very important stuff here", emphasis: []bool{true, true, true, false}}},
+		},
+		{
+			name:   "srt other markup is dropped",
+			format: SRT,
+			in:     "1\n00:00:00,000 --> 00:00:01,000\n<font color=\"red\">Red</font> {\\an8}top\n",
+			want:   []Cue{{Start: 0, End: 1, Text: "Red top", emphasis: []bool{false, false}}},
+		},
+		{
+			name:    "srt without a timing line",
+			format:  SRT,
+			in:      "1\n00:00:00,000 --> 00:00:01,000\nok\n\njust some text\n",
+			wantErr: "line 5: expected a cue timing",
+		},
+		{
+			name:    "srt cue ending before it starts",
+			format:  SRT,
+			in:      "1\n00:00:02,000 --> 00:00:01,000\nback\n",
+			wantErr: "cue ends before it starts",
+		},
+		{
+			name:    "srt bad timestamp",
+			format:  SRT,
+			in:      "1\n00:00:xx,000 --> 00:00:01,000\nbad\n",
+			wantErr: "line 2: invalid timestamp",
+		},
+		{
+			name:   "vtt with header, NOTE and STYLE blocks",
+			format: VTT,
+			in: "WEBVTT - a title\n\nNOTE this is a comment\nover two lines\n\nSTYLE\n::cue { color: yellow }\n\n" +
+				"00:00:01.000 --> 00:00:02.000 align:start position:10%\nFirst\n\n" +
+				"intro\n00:01.500 --> 00:03.000\nSecond cue\n",
+			want: []Cue{
+				{Start: 1, End: 2, Text: "First", emphasis: []bool{false}},
+				{Start: 1.5, End: 3, Text: "Second cue", emphasis: []bool{false, false}},
+			},
+		},
+		{
+			name:   "vtt voice tags set the speaker",
+			format: VTT,
+			in:     "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\n<v Anna Smith>Hi <b>Bob</b></v>\n\n00:00:01.000 --> 00:00:02.000\n<v.loud Bob>Hey\n",
+			want: []Cue{
+				{Start: 0, End: 1, Text: "Hi Bob", Speaker: "Anna Smith", emphasis: []bool{false, true}},
+				{Start: 1, End: 2, Text: "Hey", Speaker: "Bob", emphasis: []bool{false}},
+			},
+		},
+		{
+			name:   "vtt cues without text are skipped",
+			format: VTT,
+			in:     "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\n<i></i>\n\n00:00:01.000 --> 00:00:02.000\nkept\n",
+			want:   []Cue{{Start: 1, End: 2, Text: "kept", emphasis: []bool{false}}},
+		},
+		{
+			name:    "vtt without a header",
+			format:  VTT,
+			in:      "00:00:00.000 --> 00:00:01.000\nno header\n",
+			wantErr: "missing WEBVTT header",
+		},
+		{
+			name:    "vtt cue without an end",
+			format:  VTT,
+			in:      "WEBVTT\n\n00:00:00.000 -->\nopen\n",
+			wantErr: "missing cue end",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCues([]byte(tt.in), tt.format)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseCues() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCues() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCues() =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCues(t *testing.T) {
+	word := func(time, duration float64, value, speaker string) types.Word {
+		return types.Word{Time: time, Duration: duration, Value: value, Speaker: speaker}
+	}
+	tests := []struct {
+		name  string
+		words []types.Word
+		opts  types.SubtitlesOptions
+		want  []Cue
+	}{
+		{
+			name:  "words with durations",
+			words: []types.Word{word(0, 0.4, "one", ""), word(0.5, 0.4, "two", "")},
+			want:  []Cue{{Start: 0, End: 0.9, Text: "one two"}},
+		},
+		{
+			name:  "words without durations end at the next word, the last after a minimum",
+			words: []types.Word{word(0, 0, "one", ""), word(0.7, 0, "two", "")},
+			want:  []Cue{{Start: 0, End: 0.7 + minCueDuration, Text: "one two"}},
+		},
+		{
+			name:  "a cue without durations ends when the next starts",
+			words: []types.Word{word(0, 0, "one", "A"), word(0.3, 0, "two", "B")},
+			want: []Cue{
+				{Start: 0, End: 0.3, Text: "one", Speaker: "A"},
+				{Start: 0.3, End: 0.3 + minCueDuration, Text: "two", Speaker: "B"},
+			},
+		},
+		{
+			name:  "lines wrap at max chars per line",
+			words: []types.Word{word(0, 0.4, "one", ""), word(0.5, 0.4, "two", ""), word(1, 0.4, "three", "")},
+			opts:  types.SubtitlesOptions{MaxCharsPerLine: 7},
+			want: []Cue{
+				{Start: 0, End: 0.9, Text: "one two"},
+				{Start: 1, End: 1.4, Text: "three"},
+			},
+		},
+		{
+			name:  "max lines",
+			words: []types.Word{word(0, 0.4, "one", ""), word(0.5, 0.4, "two", ""), word(1, 0.4, "three", "")},
+			opts:  types.SubtitlesOptions{MaxCharsPerLine: 5, MaxLines: 2},
+			want: []Cue{
+				{Start: 0, End: 0.9, Text: "one\ntwo"},
+				{Start: 1, End: 1.4, Text: "three"},
+			},
+		},
+		{
+			name:  "max cue duration",
+			words: []types.Word{word(0, 1, "one", ""), word(1, 1, "two", ""), word(2, 1, "three", "")},
+			opts:  types.SubtitlesOptions{MaxCueDuration: 2},
+			want: []Cue{
+				{Start: 0, End: 2, Text: "one two"},
+				{Start: 2, End: 3, Text: "three"},
+			},
+		},
+		{
+			name:  "phrase break",
+			words: []types.Word{word(0, 0.4, "one", ""), word(2, 0.4, "two", "")},
+			opts:  types.SubtitlesOptions{GapThreshold: 1},
+			want: []Cue{
+				{Start: 0, End: 0.4, Text: "one"},
+				{Start: 2, End: 2.4, Text: "two"},
+			},
+		},
+		{
+			name: "emphasis",
+			words: []types.Word{
+				word(0, 0.4, "one", ""),
+				{Time: 0.5, Duration: 0.4, Value: "two", Emphasis: true},
+			},
+			want: []Cue{{Start: 0, End: 0.9, Text: "one two", emphasis: []bool{false, true}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Cues(tt.words, tt.opts)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Cues() =\n%#v\nwant\n%#v", got, tt.want)
+			}
+			for i := range got {
+				g, w := got[i], tt.want[i]
+				if math.Abs(g.Start-w.Start) > 1e-9 || math.Abs(g.End-w.End) > 1e-9 ||
+					g.Text != w.Text || g.Speaker != w.Speaker || !reflect.DeepEqual(g.emphasis, w.emphasis) {
+					t.Errorf("cue %d = %#v, want %#v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	words := []types.Word{
+		{Time: 0, Duration: 0.4, Value: "Hello", Speaker: "Anna"},
+		{Time: 0.5, Duration: 0.5, Value: "there,", Speaker: "Anna", Emphasis: true},
+		{Time: 1, Duration: 0.4, Value: "friend.", Speaker: "Anna"},
+		{Time: 2, Duration: 0.6, Value: "Hi", Speaker: "Bob"},
+		{Time: 62.5, Duration: 0, Value: "late"},
+	}
+	// SRT has no speakers, so the cues are split at the gaps between them
+	opts := types.SubtitlesOptions{MaxCharsPerLine: 12, MaxLines: 2, GapThreshold: 0.5}
+
+	for _, tt := range []struct {
+		format Format
+		want   string
+	}{
+		{SRT, "1\n00:00:00,000 --> 00:00:01,400\nHello <b>there,</b>\nfriend.\n\n" +
+			"2\n00:00:02,000 --> 00:00:02,600\nHi\n\n" +
+			"3\n00:01:02,500 --> 00:01:03,000\nlate\n\n"},
+		{VTT, "WEBVTT\n\n00:00:00.000 --> 00:00:01.400\n<v Anna>Hello <b>there,</b>\nfriend.\n\n" +
+			"00:00:02.000 --> 00:00:02.600\n<v Bob>Hi\n\n" +
+			"00:01:02.500 --> 00:01:03.000\nlate\n\n"},
+	} {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Write(&out, words, tt.format, opts); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("Write() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+
+			read, err := Read(out.Bytes(), tt.format)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			var values []string
+			for _, w := range read {
+				values = append(values, w.Value)
+			}
+			if got := strings.Join(values, " "); got != "Hello there, friend. Hi late" {
+				t.Errorf("words = %q", got)
+			}
+			if !read[1].Emphasis || read[0].Emphasis || read[2].Emphasis {
+				t.Errorf("emphasis = %t %t %t, want only the second word", read[0].Emphasis, read[1].Emphasis, read[2].Emphasis)
+			}
+			if tt.format == VTT && (read[0].Speaker != "Anna" || read[3].Speaker != "Bob") {
+				t.Errorf("speakers = %q, %q", read[0].Speaker, read[3].Speaker)
+			}
+			// words share the time of their cue end to end
+			if read[0].Time != 0 || read[2].Time+read[2].Duration != 1.4 || read[4].Time != 62.5 {
+				t.Errorf("word times = %+v", read)
+			}
+
+			// writing what was read gives the same file
+			var again bytes.Buffer
+			if err := Write(&again, read, tt.format, opts); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != out.String() {
+				t.Errorf("second Write() =\n%s\nwant\n%s", again.String(), out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Package transcript reads and writes transcripts as JSON word lists, SRT
+// and WebVTT.
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// Format is a transcript file format.
+type Format string
+
+const (
+	JSON Format = "json" // word list, the format the renderer reads
+	SRT  Format = "srt"
+	VTT  Format = "vtt"
+)
+
+var Formats = []Format{JSON, SRT, VTT}
+
+// ParseFormat returns the format called name, which may be a file extension.
+func ParseFormat(name string) (Format, error) {
+	f := Format(strings.ToLower(strings.TrimPrefix(name, ".")))
+	for _, known := range Formats {
+		if f == known {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown transcript format %q, expected json, srt or vtt", name)
+}
+
+// FormatOf returns the format of a file from its extension.
+func FormatOf(path string) (Format, error) {
+	return ParseFormat(filepath.Ext(path))
+}
+
+// word is a Word as written in JSON transcripts, without the fields the
+// renderer computes.
+type word struct {
+	Time     float64          `json:"time"`
+	Duration float64          `json:"duration"`
+	Value    string           `json:"word"`
+	Speaker  string           `json:"speaker,omitempty"`
+	Emphasis bool             `json:"emphasis,omitempty"`
+	Style    *types.WordStyle `json:"style,omitempty"`
+}
+
+// Read parses a transcript. Words of SRT and WebVTT cues share the time of
+// their cue in proportion to their length.
+func Read(data []byte, f Format) ([]types.Word, error) {
+	switch f {
+	case JSON:
+		var words []types.Word
+		if err := json.Unmarshal(data, &words); err != nil {
+			return nil, fmt.Errorf("invalid JSON transcript: %v", err)
+		}
+		return words, nil
+	case SRT, VTT:
+		cues, err := parseCues(data, f)
+		if err != nil {
+			return nil, err
+		}
+		var words []types.Word
+		for _, c := range cues {
+			words = append(words, c.words()...)
+		}
+		return words, nil
+	}
+	return nil, fmt.Errorf("unknown transcript format %q", f)
+}
+
+// ReadFile reads a transcript in the format given by the extension of path.
+func ReadFile(path string) ([]types.Word, error) {
+	f, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	words, err := Read(data, f)
+	if err != nil {
+		return nil, fmt.Errorf("file %s: %v", path, err)
+	}
+	return words, nil
+}
+
+// Marshal encodes words as a JSON transcript.
+func Marshal(words []types.Word) ([]byte, error) {
+	out := make([]word, len(words))
+	for i, w := range words {
+		out[i] = word{w.Time, w.Duration, w.Value, w.Speaker, w.Emphasis, w.Style}
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// Write encodes words in format f. SRT and WebVTT cues are grouped with the
+// cue options of opts, see Cues, and emphasised words are written in bold.
+func Write(w io.Writer, words []types.Word, f Format, opts types.SubtitlesOptions) error {
+	switch f {
+	case JSON:
+		data, err := Marshal(words)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case SRT, VTT:
+		buf := &bytes.Buffer{}
+		if f == VTT {
+			buf.WriteString("WEBVTT\n\n")
+		}
+		for i, c := range Cues(words, opts) {
+			text := c.markedText()
+			if f == VTT && c.Speaker != "" {
+				text = "<v " + c.Speaker + ">" + text
+			}
+			if f == SRT {
+				fmt.Fprintf(buf, "%d\n", i+1)
+			}
+			fmt.Fprintf(buf, "%s --> %s\n%s\n\n", timestamp(c.Start, f), timestamp(c.End, f), text)
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return fmt.Errorf("unknown transcript format %q", f)
+}
@@ -8,45 +8,137 @@ type Updater interface {
 csdfjsdlf jsfkjsdhf kljsdfh
 */
 type SubtitlesOptions struct {
-	FontFamily            string  `firestore:"fontFamily"`
-	FontSize              float64 `firestore:"fontSize"`
-	FontColor             string  `firestore:"fontColor"`
-	FontSelectedColor     string  `firestore:"fontSelectedColor"`
-	StrokeColor           string  `firestore:"strokeColor"`
-	StrokeWidth           float64 `firestore:"strokeWidth"`
-	HighlightColor        string  `firestore:"highlightColor"`
-	HighlightBorderRadius int     `firestore:"highlightBorderRadius"`
-	HighlightPadding      float64 `firestore:"highlightPadding"`
-	Padding               int     `firestore:"padding"`
-	WordSpacing           int     `firestore:"wordSpacing"`
-	LineSpacing           float64 `firestore:"lineSpacing"`
-	RTL                   bool    `firestore:"rtl"`
-	MaxLines              int     `firestore:"maxLines"`
-	Center                bool    `firestore:"center"`
-	Alignment             string  `firestore:"alignment"`
+	FontFamily            string                  `firestore:"fontFamily"`
+	FontSize              float64                 `firestore:"fontSize"`
+	FontColor             string                  `firestore:"fontColor"`
+	FontSelectedColor     string                  `firestore:"fontSelectedColor"`
+	StrokeColor           string                  `firestore:"strokeColor"`
+	StrokeWidth           float64                 `firestore:"strokeWidth"`
+	HighlightColor        string                  `firestore:"highlightColor"`
+	HighlightBorderRadius int                     `firestore:"highlightBorderRadius"`
+	HighlightPadding      float64                 `firestore:"highlightPadding"`
+	HighlightEasing       string                  `firestore:"highlightEasing"`
+	Padding               int                     `firestore:"padding"`
+	WordSpacing           int                     `firestore:"wordSpacing"`
+	LineSpacing           float64                 `firestore:"lineSpacing"`
+	RTL                   bool                    `firestore:"rtl"`
+	MaxLines              int                     `firestore:"maxLines"`
+	Center                bool                    `firestore:"center"`
+	Alignment             string                  `firestore:"alignment"`
+	Style                 string                  `firestore:"style"`
+	TypewriterScope       string                  `firestore:"typewriterScope"`
+	TypewriterCursor      string                  `firestore:"typewriterCursor"`
+	CursorBlinkRate       float64                 `firestore:"cursorBlinkRate"`
+	GhostOpacity          float64                 `firestore:"ghostOpacity"`
+	Layout                string                  `firestore:"layout"`
+	PopFontSize           float64                 `firestore:"popFontSize"`
+	PopMaxWidth           float64                 `firestore:"popMaxWidth"`
+	PopSpring             SpringOptions           `firestore:"popSpring"`
+	PopEntryDuration      float64                 `firestore:"popEntryDuration"` // deprecated, see PopSpring
+	PopExitDuration       float64                 `firestore:"popExitDuration"`  // deprecated, see PopSpring
+	EmphasisWords         []string                `firestore:"emphasisWords"`
+	EmphasizeNumbers      bool                    `firestore:"emphasizeNumbers"`
+	EmphasisStyle         WordStyle               `firestore:"emphasisStyle"`
+	SpeakerStyles         map[string]SpeakerStyle `firestore:"speakerStyles"`
+	SpeakerNewPage        bool                    `firestore:"speakerNewPage"`
+	GapThreshold          float64                 `firestore:"gapThreshold"`
+	GapFade               float64                 `firestore:"gapFade"`
+	PhraseBreak           string                  `firestore:"phraseBreak"`
+	MaxCharsPerLine       int                     `firestore:"maxCharsPerLine"`
+	MaxCPS                float64                 `firestore:"maxCPS"` // advisory, only warned about
+	MinCueDuration        float64                 `firestore:"minCueDuration"`
+	MaxCueDuration        float64                 `firestore:"maxCueDuration"`
+	NoBreakAfter          []string                `firestore:"noBreakAfter"`
+	BreakAtPunctuation    bool                    `firestore:"breakAtPunctuation"`
+	BalanceLines          bool                    `firestore:"balanceLines"`
+	Timing                Timing                  `firestore:"timing"`
+	MusicGain             float64                 `firestore:"musicGain"`
+	Encoding              Encoding                `firestore:"encoding"`
 	HighlightScale        float64
 	TextOffsetX           float64
 	TextOffsetY           float64
 	TextOpacity           float64
-	FPS                   int
+	Reveal                bool
+	RevealProgress        float64
+	CursorVisible         bool
+	WordProgress          float64
+	LineProgress          float64
+	WordElapsed           float64
+	Time                  float64
+	FPS                   float64
 	Width                 int
 	Height                int
+	Duration              float64
 }
 
 type Word struct {
-	Time        float64 `json:"time"`
-	Duration    float64 `json:"duration"`
-	Value       string  `json:"word"`
-	Frames      int64   `json:"frames"`
-	StartFrames int64   `json:"startFrames"`
+	Time        float64    `json:"time"`
+	Duration    float64    `json:"duration"`
+	Value       string     `json:"word"`
+	Frames      int64      `json:"frames"`
+	StartFrames int64      `json:"startFrames"`
+	Speaker     string     `json:"speaker,omitempty"`
+	Emphasis    bool       `json:"emphasis,omitempty"`
+	Style       *WordStyle `json:"style,omitempty"`
+}
+
+// WordStyle overrides the base SubtitlesOptions for a single word. Zero
+// values keep the base option.
+type WordStyle struct {
+	Color          string  `json:"color,omitempty" firestore:"color"`
+	HighlightColor string  `json:"highlightColor,omitempty" firestore:"highlightColor"`
+	Bold           bool    `json:"bold,omitempty" firestore:"bold"`
+	Scale          float64 `json:"scale,omitempty" firestore:"scale"`
+	Emoji          string  `json:"emoji,omitempty" firestore:"emoji"`
+}
+
+// SpeakerStyle holds the colours and position of one speaker's captions.
+// Anchor is "left", "right" or "center" and Alignment is "top", "bottom" or
+// "center"; empty values keep the base options.
+type SpeakerStyle struct {
+	FontColor         string `firestore:"fontColor"`
+	FontSelectedColor string `firestore:"fontSelectedColor"`
+	HighlightColor    string `firestore:"highlightColor"`
+	Anchor            string `firestore:"anchor"`
+	Alignment         string `firestore:"alignment"`
+}
+
+// Timing corrects transcripts that drift against the video or come from a
+// sped-up or trimmed source. Times are in seconds.
+type Timing struct {
+	Offset  float64      `json:"offset" firestore:"offset"`   // added to every timestamp
+	Scale   float64      `json:"scale" firestore:"scale"`     // speed factor, 0 means 1
+	Anchors []TimeAnchor `json:"anchors" firestore:"anchors"` // piecewise-linear correction
+}
+
+// TimeAnchor pins a transcript timestamp to the matching video timestamp.
+type TimeAnchor struct {
+	Transcript float64 `json:"transcript" firestore:"transcript"`
+	Video      float64 `json:"video" firestore:"video"`
+}
+
+// Encoding controls how the rendered video is encoded. Profile names a
+// platform preset such as "tiktok-1080x1920" whose settings are used for the
+// fields left empty. Width and Height, when set, fit the output into that
+// size with padding.
+type Encoding struct {
+	Profile     string `json:"profile,omitempty" firestore:"profile"`
+	Codec       string `json:"codec,omitempty" firestore:"codec"`     // x264, x265, vp9 or av1
+	CRF         int    `json:"crf,omitempty" firestore:"crf"`         // 0 uses the codec default
+	Bitrate     string `json:"bitrate,omitempty" firestore:"bitrate"` // such as "6M", replaces CRF
+	Preset      string `json:"preset,omitempty" firestore:"preset"`   // encoder speed preset
+	PixelFormat string `json:"pixelFormat,omitempty" firestore:"pixelFormat"`
+	Container   string `json:"container,omitempty" firestore:"container"` // mp4, mkv or webm
+	Width       int    `json:"width,omitempty" firestore:"width"`
+	Height      int    `json:"height,omitempty" firestore:"height"`
 }
 
 type Interpolator func(float64) float64
 
 type SpringOptions struct {
-	Stiffness float64 // Spring stiffness
-	Damping   float64 // Damping coefficient
-	Mass      float64 // Mass of the object
+	Stiffness float64 `firestore:"stiffness"` // Spring stiffness
+	Damping   float64 `firestore:"damping"`   // Damping coefficient
+	Mass      float64 `firestore:"mass"`      // Mass of the object
 }
 
 type Style struct {
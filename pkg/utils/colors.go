@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// ParseHexColor parses colours in the "rgb", "rrggbb" or "rrggbbaa" forms,
+// with or without a leading '#'.
+func ParseHexColor(s string) (color.NRGBA, error) {
+	x := strings.TrimPrefix(s, "#")
+	c := color.NRGBA{A: 255}
+	var err error
+	switch len(x) {
+	case 3:
+		_, err = fmt.Sscanf(x, "%1x%1x%1x", &c.R, &c.G, &c.B)
+		c.R |= c.R << 4
+		c.G |= c.G << 4
+		c.B |= c.B << 4
+	case 6:
+		_, err = fmt.Sscanf(x, "%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 8:
+		_, err = fmt.Sscanf(x, "%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	default:
+		err = fmt.Errorf("unexpected length %d", len(x))
+	}
+	if err != nil {
+		return c, fmt.Errorf("invalid hex colour %q: %v", s, err)
+	}
+	return c, nil
+}
+
+// HexColorWithOpacity parses s and multiplies its alpha by opacity. Invalid
+// colours fall back to black, matching gg.SetHexColor.
+func HexColorWithOpacity(s string, opacity float64) color.NRGBA {
+	c, _ := ParseHexColor(s)
+	c.A = uint8(float64(c.A) * max(0, min(opacity, 1)))
+	return c
+}
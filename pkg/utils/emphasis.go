@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// markAsterisks strips *emphasis* markers from the transcript and flags the
+// words they cover. A marked phrase may span several words.
+func markAsterisks(words []types.Word) {
+	open := false
+	for i := range words {
+		n := strings.Count(words[i].Value, "*")
+		if n == 0 {
+			words[i].Emphasis = words[i].Emphasis || open
+			continue
+		}
+		words[i].Value = strings.ReplaceAll(words[i].Value, "*", "")
+		words[i].Emphasis = true
+		if n%2 == 1 {
+			open = !open
+		}
+	}
+}
+
+// NormalizeWord lowercases s and trims the punctuation around it so words
+// can be compared with keyword lists.
+func NormalizeWord(s string) string {
+	return strings.ToLower(strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	}))
+}
+
+func containsDigit(s string) bool {
+	return strings.IndexFunc(s, unicode.IsDigit) >= 0
+}
+
+// ApplyEmphasis flags the words and phrases listed in opts.EmphasisWords and,
+// if opts.EmphasizeNumbers is set, every word containing a digit. Flagged
+// words without a style of their own get a copy of opts.EmphasisStyle.
+func ApplyEmphasis(words []types.Word, opts types.SubtitlesOptions) {
+	phrases := [][]string{}
+	for _, k := range opts.EmphasisWords {
+		phrase := []string{}
+		for _, f := range strings.Fields(k) {
+			phrase = append(phrase, NormalizeWord(f))
+		}
+		if len(phrase) > 0 {
+			phrases = append(phrases, phrase)
+		}
+	}
+
+	for i := range words {
+		if opts.EmphasizeNumbers && containsDigit(words[i].Value) {
+			words[i].Emphasis = true
+		}
+		for _, phrase := range phrases {
+			if matchPhrase(words[i:], phrase) {
+				for j := range phrase {
+					words[i+j].Emphasis = true
+				}
+			}
+		}
+	}
+
+	for i := range words {
+		if words[i].Emphasis && words[i].Style == nil {
+			style := opts.EmphasisStyle
+			words[i].Style = &style
+		}
+	}
+}
+
+func matchPhrase(words []types.Word, phrase []string) bool {
+	if len(words) < len(phrase) {
+		return false
+	}
+	for j, p := range phrase {
+		if NormalizeWord(words[j].Value) != p {
+			return false
+		}
+	}
+	return true
+}
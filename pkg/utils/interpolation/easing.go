@@ -0,0 +1,321 @@
+package interpolation
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// Easing maps linear progress in [0,1] to eased progress. Most easings start
+// at 0 and end at 1; back and elastic overshoot in between.
+type Easing func(t float64) float64
+
+// Ease returns an interpolator from `from` to `to` shaped by e.
+func Ease(from, to float64, e Easing) types.Interpolator {
+	return func(t float64) float64 {
+		return from + (to-from)*e(t)
+	}
+}
+
+func clamp01(t float64) float64 {
+	return math.Max(0, math.Min(1, t))
+}
+
+// easeOut and easeInOut derive the out and in-out variants from an in easing.
+func easeOut(in Easing) Easing {
+	return func(t float64) float64 { return 1 - in(1-t) }
+}
+
+func easeInOut(in Easing) Easing {
+	return func(t float64) float64 {
+		if t < 0.5 {
+			return in(2*t) / 2
+		}
+		return 1 - in(2-2*t)/2
+	}
+}
+
+func LinearEasing(t float64) float64 { return t }
+
+func InQuad(t float64) float64  { return t * t }
+func InCubic(t float64) float64 { return t * t * t }
+func InQuart(t float64) float64 { return t * t * t * t }
+func InQuint(t float64) float64 { return t * t * t * t * t }
+func InSine(t float64) float64  { return 1 - math.Cos(t*math.Pi/2) }
+func InCirc(t float64) float64  { return 1 - math.Sqrt(1-t*t) }
+
+func InExpo(t float64) float64 {
+	if t <= 0 {
+		return 0
+	}
+	return math.Pow(2, 10*t-10)
+}
+
+// InBack overshoots below 0 before heading to 1.
+func InBack(t float64) float64 {
+	const c1 = 1.70158
+	const c3 = c1 + 1
+	return c3*t*t*t - c1*t*t
+}
+
+func InElastic(t float64) float64 {
+	if t <= 0 || t >= 1 {
+		return clamp01(t)
+	}
+	const c4 = 2 * math.Pi / 3
+	return -math.Pow(2, 10*t-10) * math.Sin((t*10-10.75)*c4)
+}
+
+func OutBounce(t float64) float64 {
+	const n1 = 7.5625
+	const d1 = 2.75
+	switch {
+	case t < 1/d1:
+		return n1 * t * t
+	case t < 2/d1:
+		t -= 1.5 / d1
+		return n1*t*t + 0.75
+	case t < 2.5/d1:
+		t -= 2.25 / d1
+		return n1*t*t + 0.9375
+	default:
+		t -= 2.625 / d1
+		return n1*t*t + 0.984375
+	}
+}
+
+func InBounce(t float64) float64 { return 1 - OutBounce(1-t) }
+
+var (
+	OutQuad      = easeOut(InQuad)
+	InOutQuad    = easeInOut(InQuad)
+	OutCubic     = easeOut(InCubic)
+	InOutCubic   = easeInOut(InCubic)
+	OutQuart     = easeOut(InQuart)
+	InOutQuart   = easeInOut(InQuart)
+	OutQuint     = easeOut(InQuint)
+	InOutQuint   = easeInOut(InQuint)
+	OutSine      = easeOut(InSine)
+	InOutSine    = easeInOut(InSine)
+	OutCirc      = easeOut(InCirc)
+	InOutCirc    = easeInOut(InCirc)
+	OutExpo      = easeOut(InExpo)
+	InOutExpo    = easeInOut(InExpo)
+	OutBack      = easeOut(InBack)
+	InOutBack    = easeInOut(InBack)
+	OutElastic   = easeOut(InElastic)
+	InOutElastic = easeInOut(InElastic)
+	InOutBounce  = easeInOut(InBounce)
+)
+
+// CubicBezier returns the easing of CSS cubic-bezier(x1, y1, x2, y2). x1 and
+// x2 are clamped to [0,1] so the curve stays a function of time.
+func CubicBezier(x1, y1, x2, y2 float64) Easing {
+	x1, x2 = clamp01(x1), clamp01(x2)
+
+	// polynomial coefficients of the curve, with P0 = (0,0) and P3 = (1,1)
+	cx := 3 * x1
+	bx := 3*(x2-x1) - cx
+	ax := 1 - cx - bx
+	cy := 3 * y1
+	by := 3*(y2-y1) - cy
+	ay := 1 - cy - by
+
+	sampleX := func(s float64) float64 { return ((ax*s+bx)*s + cx) * s }
+	sampleY := func(s float64) float64 { return ((ay*s+by)*s + cy) * s }
+	slopeX := func(s float64) float64 { return (3*ax*s+2*bx)*s + cx }
+
+	const epsilon = 1e-7
+	solve := func(x float64) float64 {
+		// Newton's method converges quickly for most curves
+		s := x
+		for i := 0; i < 8; i++ {
+			d := sampleX(s) - x
+			if math.Abs(d) < epsilon {
+				return s
+			}
+			slope := slopeX(s)
+			if math.Abs(slope) < 1e-6 {
+				break
+			}
+			s -= d / slope
+		}
+
+		// fall back to bisection, which always converges
+		lo, hi := 0.0, 1.0
+		s = x
+		for lo < hi {
+			d := sampleX(s)
+			if math.Abs(d-x) < epsilon {
+				return s
+			}
+			if x > d {
+				lo = s
+			} else {
+				hi = s
+			}
+			if hi-lo < epsilon {
+				break
+			}
+			s = (lo + hi) / 2
+		}
+		return s
+	}
+
+	return func(t float64) float64 {
+		if t <= 0 || t >= 1 {
+			return clamp01(t)
+		}
+		return sampleY(solve(t))
+	}
+}
+
+// Steps returns the easing of CSS steps(n, position), where position is one
+// of "jump-start", "jump-end", "jump-none" or "jump-both" ("start" and "end"
+// are accepted as aliases). An empty position means "jump-end".
+func Steps(n int, position string) (Easing, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("steps needs at least 1 step, got %d", n)
+	}
+	steps := float64(n)
+	switch position {
+	case "", "end", "jump-end":
+		return func(t float64) float64 {
+			return clamp01(math.Floor(t*steps) / steps)
+		}, nil
+	case "start", "jump-start":
+		return func(t float64) float64 {
+			return clamp01((math.Floor(t*steps) + 1) / steps)
+		}, nil
+	case "jump-both":
+		return func(t float64) float64 {
+			return clamp01((math.Floor(t*steps) + 1) / (steps + 1))
+		}, nil
+	case "jump-none":
+		if n < 2 {
+			return nil, fmt.Errorf("steps with jump-none needs at least 2 steps, got %d", n)
+		}
+		return func(t float64) float64 {
+			return clamp01(math.Floor(t*steps) / (steps - 1))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown steps position %q", position)
+}
+
+// Easings holds the easings that can be looked up by name. Keys are
+// lowercase with dashes and underscores removed.
+var Easings = map[string]Easing{
+	"linear":    LinearEasing,
+	"ease":      CubicBezier(0.25, 0.1, 0.25, 1),
+	"easein":    CubicBezier(0.42, 0, 1, 1),
+	"easeout":   CubicBezier(0, 0, 0.58, 1),
+	"easeinout": CubicBezier(0.42, 0, 0.58, 1),
+	"stepstart": func(t float64) float64 { return clamp01(math.Floor(t) + 1) },
+	"stepend":   func(t float64) float64 { return math.Floor(clamp01(t)) },
+
+	"easeinquad":    InQuad,
+	"easeoutquad":   OutQuad,
+	"easeinoutquad": InOutQuad,
+
+	"easeincubic":    InCubic,
+	"easeoutcubic":   OutCubic,
+	"easeinoutcubic": InOutCubic,
+
+	"easeinquart":    InQuart,
+	"easeoutquart":   OutQuart,
+	"easeinoutquart": InOutQuart,
+
+	"easeinquint":    InQuint,
+	"easeoutquint":   OutQuint,
+	"easeinoutquint": InOutQuint,
+
+	"easeinsine":    InSine,
+	"easeoutsine":   OutSine,
+	"easeinoutsine": InOutSine,
+
+	"easeincirc":    InCirc,
+	"easeoutcirc":   OutCirc,
+	"easeinoutcirc": InOutCirc,
+
+	"easeinexpo":    InExpo,
+	"easeoutexpo":   OutExpo,
+	"easeinoutexpo": InOutExpo,
+
+	"easeinback":    InBack,
+	"easeoutback":   OutBack,
+	"easeinoutback": InOutBack,
+
+	"easeinelastic":    InElastic,
+	"easeoutelastic":   OutElastic,
+	"easeinoutelastic": InOutElastic,
+
+	"easeinbounce":    InBounce,
+	"easeoutbounce":   OutBounce,
+	"easeinoutbounce": InOutBounce,
+}
+
+func normalizeEasingName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(name))
+}
+
+// LookupEasing resolves an easing from its name ("easeOutBack",
+// "ease-in-out-cubic", "linear"...) or from a CSS timing function such as
+// "cubic-bezier(0.34, 1.56, 0.64, 1)" or "steps(4, jump-start)".
+func LookupEasing(name string) (Easing, error) {
+	name = strings.TrimSpace(name)
+	if fn, args, ok := parseCall(name); ok {
+		switch normalizeEasingName(fn) {
+		case "cubicbezier":
+			if len(args) != 4 {
+				return nil, fmt.Errorf("cubic-bezier takes 4 arguments, got %d", len(args))
+			}
+			p := [4]float64{}
+			for i, arg := range args {
+				v, err := strconv.ParseFloat(arg, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid cubic-bezier argument %q: %v", arg, err)
+				}
+				p[i] = v
+			}
+			if p[0] < 0 || p[0] > 1 || p[2] < 0 || p[2] > 1 {
+				return nil, fmt.Errorf("cubic-bezier x values must be in [0,1]: %s", name)
+			}
+			return CubicBezier(p[0], p[1], p[2], p[3]), nil
+		case "steps":
+			if len(args) < 1 || len(args) > 2 {
+				return nil, fmt.Errorf("steps takes 1 or 2 arguments, got %d", len(args))
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid steps count %q: %v", args[0], err)
+			}
+			position := ""
+			if len(args) == 2 {
+				position = args[1]
+			}
+			return Steps(n, position)
+		}
+		return nil, fmt.Errorf("unknown easing function %q", fn)
+	}
+
+	if e, ok := Easings[normalizeEasingName(name)]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown easing %q", name)
+}
+
+// parseCall splits "fn(a, b)" into its name and trimmed arguments.
+func parseCall(s string) (string, []string, bool) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return "", nil, false
+	}
+	args := strings.Split(s[open+1:len(s)-1], ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return strings.TrimSpace(s[:open]), args, true
+}
@@ -0,0 +1,201 @@
+package interpolation
+
+import (
+	"math"
+	"testing"
+)
+
+const tolerance = 1e-6
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < tolerance
+}
+
+func TestCubicBezier(t *testing.T) {
+	curves := map[string][4]float64{
+		"ease":        {0.25, 0.1, 0.25, 1},
+		"ease-in":     {0.42, 0, 1, 1},
+		"ease-out":    {0, 0, 0.58, 1},
+		"ease-in-out": {0.42, 0, 0.58, 1},
+		"linear":      {0, 0, 1, 1},
+		"steep":       {0.9, 0, 0.1, 1},
+	}
+	for name, p := range curves {
+		t.Run(name, func(t *testing.T) {
+			e := CubicBezier(p[0], p[1], p[2], p[3])
+			if got := e(0); got != 0 {
+				t.Errorf("at 0 = %v, want 0", got)
+			}
+			if got := e(1); got != 1 {
+				t.Errorf("at 1 = %v, want 1", got)
+			}
+			prev := 0.0
+			for i := 1; i <= 1000; i++ {
+				x := float64(i) / 1000
+				y := e(x)
+				if y < prev-tolerance {
+					t.Fatalf("decreases at %v: %v after %v", x, y, prev)
+				}
+				prev = y
+			}
+		})
+	}
+
+	if got := CubicBezier(0, 0, 1, 1)(0.3); !near(got, 0.3) {
+		t.Errorf("linear bezier at 0.3 = %v, want 0.3", got)
+	}
+	if got := CubicBezier(0.34, 1.56, 0.64, 1); got(0.5) <= 1 {
+		t.Errorf("overshooting bezier at 0.5 = %v, want above 1", got(0.5))
+	}
+}
+
+func TestPenner(t *testing.T) {
+	tests := []struct {
+		name string
+		e    Easing
+		half float64
+	}{
+		{"easeInQuad", InQuad, 0.25},
+		{"easeOutQuad", OutQuad, 0.75},
+		{"easeInOutQuad", InOutQuad, 0.5},
+		{"easeInCubic", InCubic, 0.125},
+		{"easeOutCubic", OutCubic, 0.875},
+		{"easeInOutCubic", InOutCubic, 0.5},
+		{"easeInQuart", InQuart, 0.0625},
+		{"easeOutQuart", OutQuart, 0.9375},
+		{"easeInOutQuart", InOutQuart, 0.5},
+		{"easeInQuint", InQuint, 0.03125},
+		{"easeOutQuint", OutQuint, 0.96875},
+		{"easeInOutQuint", InOutQuint, 0.5},
+		{"easeInSine", InSine, 1 - math.Sqrt2/2},
+		{"easeOutSine", OutSine, math.Sqrt2 / 2},
+		{"easeInOutSine", InOutSine, 0.5},
+		{"easeInCirc", InCirc, 1 - math.Sqrt(0.75)},
+		{"easeOutCirc", OutCirc, math.Sqrt(0.75)},
+		{"easeInOutCirc", InOutCirc, 0.5},
+		{"easeInExpo", InExpo, math.Pow(2, -5)},
+		{"easeOutExpo", OutExpo, 1 - math.Pow(2, -5)},
+		{"easeInOutExpo", InOutExpo, 0.5},
+		{"easeInBack", InBack, -0.0876975},
+		{"easeOutBack", OutBack, 1.0876975},
+		{"easeInOutBack", InOutBack, 0.5},
+		{"easeInElastic", InElastic, -0.015625},
+		{"easeOutElastic", OutElastic, 1.015625},
+		{"easeInOutElastic", InOutElastic, 0.5},
+		{"easeInBounce", InBounce, 0.234375},
+		{"easeOutBounce", OutBounce, 0.765625},
+		{"easeInOutBounce", InOutBounce, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range []struct{ t, want float64 }{{0, 0}, {0.5, tt.half}, {1, 1}} {
+				if got := tt.e(c.t); !near(got, c.want) {
+					t.Errorf("at %v = %v, want %v", c.t, got, c.want)
+				}
+			}
+			looked, err := LookupEasing(tt.name)
+			if err != nil {
+				t.Fatalf("LookupEasing(%q): %v", tt.name, err)
+			}
+			if got := looked(0.5); !near(got, tt.half) {
+				t.Errorf("looked up at 0.5 = %v, want %v", got, tt.half)
+			}
+		})
+	}
+
+	// back overshoots below 0 on the way in
+	if got := InBack(0.5); got >= 0 {
+		t.Errorf("easeInBack at 0.5 = %v, want below 0", got)
+	}
+}
+
+func TestSteps(t *testing.T) {
+	at := []float64{0, 0.2, 0.25, 0.5, 0.74, 0.75, 0.99, 1}
+	tests := []struct {
+		position string
+		want     []float64
+	}{
+		{"", []float64{0, 0, 0.25, 0.5, 0.5, 0.75, 0.75, 1}},
+		{"end", []float64{0, 0, 0.25, 0.5, 0.5, 0.75, 0.75, 1}},
+		{"jump-end", []float64{0, 0, 0.25, 0.5, 0.5, 0.75, 0.75, 1}},
+		{"start", []float64{0.25, 0.25, 0.5, 0.75, 0.75, 1, 1, 1}},
+		{"jump-start", []float64{0.25, 0.25, 0.5, 0.75, 0.75, 1, 1, 1}},
+		{"jump-both", []float64{0.2, 0.2, 0.4, 0.6, 0.6, 0.8, 0.8, 1}},
+		{"jump-none", []float64{0, 0, 1.0 / 3, 2.0 / 3, 2.0 / 3, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run("steps(4,"+tt.position+")", func(t *testing.T) {
+			e, err := Steps(4, tt.position)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, x := range at {
+				if got := e(x); !near(got, tt.want[i]) {
+					t.Errorf("at %v = %v, want %v", x, got, tt.want[i])
+				}
+			}
+		})
+	}
+
+	for _, bad := range []struct {
+		n        int
+		position string
+	}{{0, ""}, {1, "jump-none"}, {3, "middle"}} {
+		if _, err := Steps(bad.n, bad.position); err == nil {
+			t.Errorf("Steps(%d, %q) succeeded", bad.n, bad.position)
+		}
+	}
+
+	keywords := []struct {
+		name string
+		want []float64
+	}{
+		{"step-start", []float64{1, 1, 1, 1, 1, 1, 1, 1}},
+		{"step-end", []float64{0, 0, 0, 0, 0, 0, 0, 1}},
+	}
+	for _, kw := range keywords {
+		e, err := LookupEasing(kw.name)
+		if err != nil {
+			t.Fatalf("LookupEasing(%q): %v", kw.name, err)
+		}
+		for i, x := range at {
+			if got := e(x); got != kw.want[i] {
+				t.Errorf("%s at %v = %v, want %v", kw.name, x, got, kw.want[i])
+			}
+		}
+	}
+}
+
+func TestLookupEasing(t *testing.T) {
+	tests := []struct {
+		name    string
+		half    float64
+		wantErr bool
+	}{
+		{"linear", 0.5, false},
+		{"ease-in-out", 0.5, false},
+		{"EaseOutQuad", 0.75, false},
+		{"ease_in_cubic", 0.125, false},
+		{"cubic-bezier(0, 0, 1, 1)", 0.5, false},
+		{" steps(2, jump-start) ", 1, false},
+		{"steps(3)", 1.0 / 3, false},
+		{"wobble", 0, true},
+		{"cubic-bezier(0, 0, 1)", 0, true},
+		{"cubic-bezier(1.5, 0, 1, 1)", 0, true},
+		{"cubic-bezier(0, a, 1, 1)", 0, true},
+		{"steps(x)", 0, true},
+		{"steps(2, middle)", 0, true},
+		{"spin(1)", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := LookupEasing(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LookupEasing() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && !near(e(0.5), tt.half) {
+				t.Errorf("at 0.5 = %v, want %v", e(0.5), tt.half)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package interpolation
+
+import (
+	"math"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// SpringSolver is the closed-form solution of a damped spring,
+// m*a + c*v + k*(x-to) = 0, released at `from` with an initial velocity.
+// Time is in seconds.
+type SpringSolver struct {
+	from, to, velocity float64
+
+	omega float64 // undamped angular frequency
+	zeta  float64 // damping ratio
+
+	// RestDelta and RestSpeed are the distance from `to` and the speed below
+	// which the spring counts as settled.
+	RestDelta float64
+	RestSpeed float64
+}
+
+// NewSpring returns a solver for a spring moving from `from` to `to` with the
+// given initial velocity in units per second. Non-positive mass or stiffness
+// fall back to 1.
+func NewSpring(from, to, velocity float64, options types.SpringOptions) *SpringSolver {
+	mass := options.Mass
+	if mass <= 0 {
+		mass = 1
+	}
+	stiffness := options.Stiffness
+	if stiffness <= 0 {
+		stiffness = 1
+	}
+	damping := math.Max(options.Damping, 0)
+
+	precision := math.Max(math.Abs(to-from), 1e-3) * 1e-3
+	return &SpringSolver{
+		from:      from,
+		to:        to,
+		velocity:  velocity,
+		omega:     math.Sqrt(stiffness / mass),
+		zeta:      damping / (2 * math.Sqrt(stiffness*mass)),
+		RestDelta: precision,
+		RestSpeed: precision * 10,
+	}
+}
+
+// At returns the position and velocity of the spring t seconds after release.
+func (s *SpringSolver) At(t float64) (float64, float64) {
+	if t <= 0 {
+		return s.from, s.velocity
+	}
+
+	// u is the displacement from the rest position
+	u0, v0, w := s.from-s.to, s.velocity, s.omega
+	var u, v float64
+
+	switch {
+	case math.Abs(s.zeta-1) < 1e-6:
+		// critically damped
+		b := v0 + w*u0
+		decay := math.Exp(-w * t)
+		u = (u0 + b*t) * decay
+		v = (b - w*(u0+b*t)) * decay
+
+	case s.zeta < 1:
+		// under-damped, oscillates around the rest position
+		a := s.zeta * w
+		wd := w * math.Sqrt(1-s.zeta*s.zeta)
+		b := (v0 + a*u0) / wd
+		decay := math.Exp(-a * t)
+		sin, cos := math.Sincos(wd * t)
+		u = decay * (u0*cos + b*sin)
+		v = decay * ((b*wd-a*u0)*cos - (a*b+u0*wd)*sin)
+
+	default:
+		// over-damped, creeps towards the rest position
+		root := math.Sqrt(s.zeta*s.zeta - 1)
+		r1 := -w * (s.zeta - root)
+		r2 := -w * (s.zeta + root)
+		c2 := (v0 - r1*u0) / (r2 - r1)
+		c1 := u0 - c2
+		e1, e2 := math.Exp(r1*t), math.Exp(r2*t)
+		u = c1*e1 + c2*e2
+		v = r1*c1*e1 + r2*c2*e2
+	}
+
+	return s.to + u, v
+}
+
+// Value returns the position of the spring t seconds after release.
+func (s *SpringSolver) Value(t float64) float64 {
+	x, _ := s.At(t)
+	return x
+}
+
+// Settled reports whether the spring is at rest t seconds after release.
+func (s *SpringSolver) Settled(t float64) bool {
+	x, v := s.At(t)
+	return math.Abs(x-s.to) <= s.RestDelta && math.Abs(v) <= s.RestSpeed
+}
+
+// SettleTime returns the first time, sampled every millisecond, after which
+// the spring stays at rest. It gives up after a minute.
+func (s *SpringSolver) SettleTime() float64 {
+	const step = 1e-3
+	const limit = 60.0
+	settledAt := -1.0
+	for t := 0.0; t <= limit; t += step {
+		if !s.Settled(t) {
+			settledAt = -1
+			continue
+		}
+		if settledAt < 0 {
+			settledAt = t
+		}
+		// stay at rest for a full period of the undamped spring
+		if t-settledAt >= 2*math.Pi/s.omega {
+			return settledAt
+		}
+	}
+	return limit
+}
+
+// Spring returns an interpolator of the time in seconds since a spring was
+// released at rest from `from` towards `to`. It keeps moving past any
+// animation window until it settles.
+func Spring(from, to float64, options types.SpringOptions) types.Interpolator {
+	return NewSpring(from, to, 0, options).Value
+}
@@ -0,0 +1,131 @@
+package interpolation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// integrate steps m*a + c*v + k*(x-to) = 0 with RK4 to check the closed form.
+func integrate(from, to, velocity float64, o types.SpringOptions, until float64) (float64, float64) {
+	const dt = 1e-5
+	accel := func(x, v float64) float64 {
+		return (-o.Stiffness*(x-to) - o.Damping*v) / o.Mass
+	}
+	x, v := from, velocity
+	for t := 0.0; t < until-dt/2; t += dt {
+		k1x, k1v := v, accel(x, v)
+		k2x, k2v := v+dt/2*k1v, accel(x+dt/2*k1x, v+dt/2*k1v)
+		k3x, k3v := v+dt/2*k2v, accel(x+dt/2*k2x, v+dt/2*k2v)
+		k4x, k4v := v+dt*k3v, accel(x+dt*k3x, v+dt*k3v)
+		x += dt / 6 * (k1x + 2*k2x + 2*k3x + k4x)
+		v += dt / 6 * (k1v + 2*k2v + 2*k3v + k4v)
+	}
+	return x, v
+}
+
+func TestSpringSolver(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  float64
+		velocity  float64
+		options   types.SpringOptions
+		overshoot bool
+	}{
+		// zeta = 12 / (2*sqrt(300)) ~ 0.35, the ScrollingBox grow spring
+		{"under-damped", 0.9, 1, 0, types.SpringOptions{Stiffness: 300, Damping: 12, Mass: 1}, true},
+		{"under-damped with velocity", 1, 0, -4, types.SpringOptions{Stiffness: 300, Damping: 18, Mass: 1}, true},
+		{"critically damped", 0, 1, 0, types.SpringOptions{Stiffness: 100, Damping: 20, Mass: 1}, false},
+		{"critically damped heavy", 0, 10, 0, types.SpringOptions{Stiffness: 400, Damping: 80, Mass: 4}, false},
+		{"over-damped", 0, 1, 0, types.SpringOptions{Stiffness: 100, Damping: 60, Mass: 1}, false},
+		{"over-damped with velocity", 2, 0, 1, types.SpringOptions{Stiffness: 50, Damping: 40, Mass: 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSpring(tt.from, tt.to, tt.velocity, tt.options)
+
+			x, v := s.At(0)
+			if x != tt.from || v != tt.velocity {
+				t.Errorf("At(0) = %v, %v, want %v, %v", x, v, tt.from, tt.velocity)
+			}
+			for _, at := range []float64{0.01, 0.05, 0.1, 0.3, 1} {
+				x, v := s.At(at)
+				wantX, wantV := integrate(tt.from, tt.to, tt.velocity, tt.options, at)
+				if math.Abs(x-wantX) > 1e-6 || math.Abs(v-wantV) > 1e-5 {
+					t.Errorf("At(%v) = %v, %v, want %v, %v", at, x, v, wantX, wantV)
+				}
+				if got := s.Value(at); got != x {
+					t.Errorf("Value(%v) = %v, want %v", at, got, x)
+				}
+			}
+
+			overshot := false
+			for at := 0.0; at <= 2; at += 1e-3 {
+				if (s.Value(at)-tt.to)*(tt.from-tt.to) < -s.RestDelta {
+					overshot = true
+					break
+				}
+			}
+			if overshot != tt.overshoot {
+				t.Errorf("overshoots = %t, want %t", overshot, tt.overshoot)
+			}
+
+			if s.Settled(0) {
+				t.Error("settled at release")
+			}
+			settle := s.SettleTime()
+			if settle <= 0 || settle >= 60 {
+				t.Fatalf("SettleTime() = %v, want within (0, 60)", settle)
+			}
+			for at := settle; at <= settle+1; at += 1e-3 {
+				if !s.Settled(at) {
+					t.Fatalf("not settled at %v after SettleTime() = %v", at, settle)
+				}
+			}
+			if s.Settled(settle - 0.01) {
+				t.Errorf("settled 10ms before SettleTime() = %v", settle)
+			}
+		})
+	}
+}
+
+func TestSpringSettleTime(t *testing.T) {
+	// more damping settles sooner until the spring is critically damped,
+	// after which it creeps and settles later again
+	settle := func(damping float64) float64 {
+		return NewSpring(0, 1, 0, types.SpringOptions{Stiffness: 100, Damping: damping, Mass: 1}).SettleTime()
+	}
+	under, critical, over := settle(5), settle(20), settle(80)
+	if !(critical < under && critical < over) {
+		t.Errorf("settle times under=%v critical=%v over=%v, want critical fastest", under, critical, over)
+	}
+
+	// a spring that never moves is settled straight away
+	still := NewSpring(1, 1, 0, types.SpringOptions{Stiffness: 100, Damping: 20, Mass: 1})
+	if !still.Settled(0) || still.SettleTime() != 0 {
+		t.Errorf("still spring: Settled(0) = %t, SettleTime() = %v", still.Settled(0), still.SettleTime())
+	}
+
+	// undamped springs never settle and give up after a minute
+	if got := NewSpring(0, 1, 0, types.SpringOptions{Stiffness: 100, Mass: 1}).SettleTime(); got != 60 {
+		t.Errorf("undamped SettleTime() = %v, want 60", got)
+	}
+}
+
+func TestSpringDefaults(t *testing.T) {
+	// non-positive mass and stiffness fall back to 1, negative damping to 0
+	got := NewSpring(0, 1, 0, types.SpringOptions{Stiffness: -3, Damping: 2, Mass: 0})
+	want := NewSpring(0, 1, 0, types.SpringOptions{Stiffness: 1, Damping: 2, Mass: 1})
+	if !near(got.Value(0.7), want.Value(0.7)) {
+		t.Errorf("defaults at 0.7 = %v, want %v", got.Value(0.7), want.Value(0.7))
+	}
+
+	f := Spring(0.9, 1, types.SpringOptions{Stiffness: 300, Damping: 12, Mass: 1})
+	if f(0) != 0.9 {
+		t.Errorf("Spring at 0 = %v, want 0.9", f(0))
+	}
+	if !near(f(5), 1) {
+		t.Errorf("Spring at 5s = %v, want 1", f(5))
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+)
+
+// IsVisualRTL reports whether s holds Arabic text that has already been
+// shaped by garabic.Shape, which stores the runes in visual (reversed) order.
+func IsVisualRTL(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Arabic, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(clusters []string) []string {
+	reversed := make([]string, len(clusters))
+	for i, c := range clusters {
+		reversed[len(clusters)-1-i] = c
+	}
+	return reversed
+}
+
+// Graphemes splits s into grapheme clusters in spoken (logical) order. Shaped
+// Arabic is segmented as stored, so marks stay on their letter, and only the
+// order of the clusters is reversed.
+func Graphemes(s string) []string {
+	clusters := []string{}
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		clusters = append(clusters, g.Str())
+	}
+	if IsVisualRTL(s) {
+		return reverse(clusters)
+	}
+	return clusters
+}
+
+// RevealText splits s into the part that has been spoken after n grapheme
+// clusters and the part that has not, both in the same visual order as s.
+// For shaped Arabic the spoken part is on the right of the hidden one.
+func RevealText(s string, n int) (shown, hidden string) {
+	clusters := Graphemes(s)
+	n = max(0, min(n, len(clusters)))
+	if IsVisualRTL(s) {
+		return strings.Join(reverse(clusters[:n]), ""), strings.Join(reverse(clusters[n:]), "")
+	}
+	return strings.Join(clusters[:n], ""), strings.Join(clusters[n:], "")
+}
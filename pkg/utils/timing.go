@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/elweday/go-subtitles/pkg/types"
+)
+
+// ValidateTiming checks that the anchors of timing increase on both sides.
+func ValidateTiming(timing types.Timing) error {
+	if timing.Scale < 0 {
+		return fmt.Errorf("timing scale must be positive, got %v", timing.Scale)
+	}
+	for i := 1; i < len(timing.Anchors); i++ {
+		prev, curr := timing.Anchors[i-1], timing.Anchors[i]
+		if curr.Transcript <= prev.Transcript || curr.Video <= prev.Video {
+			return fmt.Errorf("timing anchors must increase: %v then %v", prev, curr)
+		}
+	}
+	return nil
+}
+
+// CorrectTime maps a transcript timestamp to video time: through the
+// piecewise-linear anchors first, then the scale and the offset.
+func CorrectTime(t float64, timing types.Timing) float64 {
+	anchors := timing.Anchors
+	switch len(anchors) {
+	case 0:
+	case 1:
+		t += anchors[0].Video - anchors[0].Transcript
+	default:
+		// find the segment around t, extending the first and last ones
+		i := sort.Search(len(anchors), func(i int) bool { return anchors[i].Transcript > t })
+		i = max(1, min(i, len(anchors)-1))
+		a, b := anchors[i-1], anchors[i]
+		t = a.Video + (t-a.Transcript)*(b.Video-a.Video)/(b.Transcript-a.Transcript)
+	}
+
+	if timing.Scale > 0 {
+		t *= timing.Scale
+	}
+	return t + timing.Offset
+}
+
+// ApplyTiming corrects the start and duration of every word.
+func ApplyTiming(words []types.Word, timing types.Timing) error {
+	if err := ValidateTiming(timing); err != nil {
+		return err
+	}
+	for i := range words {
+		start := CorrectTime(words[i].Time, timing)
+		end := CorrectTime(words[i].Time+words[i].Duration, timing)
+		words[i].Time = start
+		words[i].Duration = end - start
+	}
+	return nil
+}
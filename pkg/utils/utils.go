@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"math"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/abdullahdiaa/garabic"
 	"github.com/elweday/go-subtitles/pkg/types"
@@ -32,20 +35,35 @@ type Number interface {
 	constraints.Integer | constraints.Float
 }
 
+// TempDir is where temporary files, uploaded videos among them, are written,
+// the default directory for temporary files when empty.
+var TempDir string
+
+// WriteTemp writes data to a new temporary file and closes it. The caller
+// removes the file; nothing is left behind if writing fails.
 func WriteTemp(data []byte) (*os.File, error) {
-	f, err := os.CreateTemp("", uuid.New().String())
+	return CopyTemp(bytes.NewReader(data))
+}
+
+// CopyTemp streams r to a new temporary file and closes it. The caller
+// removes the file; nothing is left behind if copying fails.
+func CopyTemp(r io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp(TempDir, uuid.New().String())
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	_, err = f.Write(data)
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(f.Name())
 		return nil, err
 	}
 	return f, nil
 }
 
-func ReadAndConvertToFrames(jsonString []byte, frameRate int) ([]types.Word, error) {
+func ReadAndConvertToFrames(jsonString []byte, opts types.SubtitlesOptions) ([]types.Word, error) {
 	var items = []types.Word{}
 	reader := bytes.NewReader(jsonString)
 
@@ -55,12 +73,32 @@ func ReadAndConvertToFrames(jsonString []byte, frameRate int) ([]types.Word, err
 		return nil, errors.New("encoding to []Word failed: make sure the file has the appropriate format")
 	}
 
+	if err := ApplyTiming(items, opts.Timing); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Time < items[j].Time })
+	markAsterisks(items)
+	ApplyEmphasis(items, opts)
+
 	for i := range items {
-		items[i].Frames = int64(math.Round(items[i].Time * float64(frameRate)))
-		items[i].Value = garabic.Shape(items[i].Value)
+		items[i].Frames = int64(math.Round(items[i].Time * opts.FPS))
+		items[i].Value = shape(items[i].Value)
 	}
 
 	items = append([]types.Word{}, items...)
 
 	return items, nil
 }
+
+// shape joins the letters of Arabic words. Other words are only trimmed,
+// which is all garabic.Shape does to them, without its debug output on
+// stdout.
+func shape(s string) string {
+	for _, r := range s {
+		if garabic.IsArabicLetter(r) {
+			return garabic.Shape(s)
+		}
+	}
+	return strings.TrimSpace(s)
+}